@@ -0,0 +1,43 @@
+package main
+
+import (
+	"github.com/reactivego/jig/pkg"
+)
+
+// cleanMain implements "jig clean", which removes the files generated by jig.
+func cleanMain(cmd *subcommand, args []string) int {
+	var f genFlags
+	flags := cmd.flagSet()
+	f.addDryRun(flags)
+	flags.StringVarP(&f.output, "output", "o", "", "Remove the files generated into a separate package in this directory")
+	flags.StringVar(&f.outputName, "pkg", "", "Name of the package in the output dir (default base name of output dir)")
+	flags.BoolVarP(&f.verbose, "verbose", "v", false, "Print details of what jig is doing")
+	f.addJSON(flags)
+	if ok, status := parse(flags, args); !ok {
+		return status
+	}
+
+	p, err := f.newPackage(dirArg(flags, 0), flags)
+	if printedError(f.verbose, nil, err) {
+		return exitStatus(err)
+	}
+	return cleanPackage(p, f.verbose)
+}
+
+// cleanPackage removes all generated source code file(s) of the package. When
+// the package is doing a dry run, the changes are printed instead.
+func cleanPackage(p *pkg.Package, verbose bool) int {
+	messages, err := p.RemoveGeneratedSources()
+	if printedError(verbose, messages, err) {
+		return exitStatus(err)
+	}
+	if p.DryRun {
+		messages, err = p.DiffGeneratedSources(stdout)
+	} else {
+		messages, err = p.WriteGeneratedSources()
+	}
+	if printedError(verbose, messages, err) {
+		return exitStatus(err)
+	}
+	return 0
+}
@@ -0,0 +1,31 @@
+package stack
+
+import "testing"
+
+//jig:test <Foo>Stack Pop
+
+func TestFooStackPop(t *testing.T) {
+	var s FooStack
+	if _, ok := s.Pop(); ok {
+		t.Error("Pop on empty stack returned ok")
+	}
+	var v foo
+	s = append(s, v)
+	if got, ok := s.Pop(); !ok || got != v {
+		t.Errorf("Pop returned %v, %v; expected %v, true", got, ok, v)
+	}
+	if len(s) != 0 {
+		t.Errorf("Pop left %d values on the stack; expected 0", len(s))
+	}
+}
+
+//jig:test <Foo>Stack Push
+
+func TestFooStackPush(t *testing.T) {
+	var s FooStack
+	var v foo
+	s.Push(v)
+	if len(s) != 1 || s[0] != v {
+		t.Errorf("Push resulted in %v; expected [%v]", s, v)
+	}
+}
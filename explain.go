@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/reactivego/jig/templ"
+)
+
+// explainMain implements "jig explain", which describes how an identifier is resolved to
+// templates, e.g. "jig explain ObservableIntMapString". The identifier may also be given as
+// a signature, e.g. "jig explain 'ObservableInt MapString'".
+func explainMain(cmd *subcommand, args []string) int {
+	var f genFlags
+	flags := cmd.flagSet()
+	flags.BoolVarP(&f.verbose, "verbose", "v", false, "Print details of what jig is doing")
+	if ok, status := parse(flags, args); !ok {
+		return status
+	}
+	signature := flags.Arg(0)
+	if signature == "" {
+		fmt.Fprintln(os.Stderr, "explain needs an identifier to explain")
+		return exitUsage
+	}
+
+	p, err := f.newPackage(dirArg(flags, 1), flags)
+	if printedError(f.verbose, nil, err) {
+		return exitStatus(err)
+	}
+	_, err = p.Check()
+	if printedError(f.verbose, nil, err) {
+		return exitStatus(err)
+	}
+	messages := p.LoadGeneratePragmas()
+	printedError(f.verbose, messages, nil)
+	tplr := templ.NewSpecializer()
+	messages, err = p.LoadGenerics(tplr)
+	if printedError(f.verbose, messages, err) {
+		return exitStatus(err)
+	}
+	messages, err = tplr.Explain(p, signature)
+	if printedError(true, messages, err) {
+		return exitStatus(err)
+	}
+	return 0
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/reactivego/jig/pkg"
+	"github.com/reactivego/jig/templ"
+
+	"github.com/spf13/pflag"
+)
+
+// genFlags are the flags of the subcommands that generate code.
+type genFlags struct {
+	missing, regen, prune, pruneExported, nodoc, lines, tests, dryrun, verbose, json bool
+	output, outputName                                                               string
+}
+
+// add registers the flags that influence the generated code with the flag set.
+func (f *genFlags) add(flags *pflag.FlagSet) {
+	flags.BoolVarP(&f.lines, "line-directives", "l", false, "Add //line directives pointing to the template sources")
+	flags.BoolVarP(&f.nodoc, "nodoc", "n", false, "No documentation in generated files")
+	flags.StringVarP(&f.output, "output", "o", "", "Generate code into a separate package in this directory")
+	flags.StringVar(&f.outputName, "pkg", "", "Name of the package to generate code into (default base name of output dir)")
+	flags.BoolVarP(&f.prune, "prune", "p", false, "Remove generated code that is no longer referenced")
+	flags.BoolVar(&f.pruneExported, "prune-exported", false, "Also prune exported code that other packages may use")
+	flags.BoolVarP(&f.verbose, "verbose", "v", false, "Print details of what jig is doing")
+	flags.BoolVar(&f.tests, "with-tests", false, "Generate the tests of specialized templates into _test.go files")
+	f.addJSON(flags)
+}
+
+// addJSON registers the flag that prints a stream of JSON events with the flag set.
+func (f *genFlags) addJSON(flags *pflag.FlagSet) {
+	flags.BoolVar(&f.json, "json", false, "Print what jig is doing as a stream of JSON events, one per line")
+}
+
+// addDryRun registers the flags that print a diff instead of writing files with the flag set.
+func (f *genFlags) addDryRun(flags *pflag.FlagSet) {
+	flags.BoolVarP(&f.dryrun, "dry-run", "d", false, "Print a diff of the changes instead of writing files")
+	flags.BoolVar(&f.dryrun, "diff", false, "Same as --dry-run")
+}
+
+// newPackage creates the package for the dir, configured by the flags, and
+// parses the files in the package dir.
+func (f *genFlags) newPackage(dir string, flags *pflag.FlagSet) (*pkg.Package, error) {
+	p := f.packageFor(dir, flags)
+	return p, p.ParseDir()
+}
+
+// packageFor creates the package for the dir, configured by the flags, without
+// parsing the files in the package dir yet.
+func (f *genFlags) packageFor(dir string, flags *pflag.FlagSet) *pkg.Package {
+	p := pkg.NewPackage(dir)
+	p.Nodoc = f.nodoc
+	p.DryRun = f.dryrun
+	p.PruneExported = f.pruneExported
+	p.LineDirectives = f.lines
+	p.WithTests = f.tests
+	p.Command = command(flags)
+	p.OutputDir = f.output
+	p.OutputName = f.outputName
+	if f.json {
+		// Keep stdout for the events, print any other output to stderr instead.
+		p.Events = jsonEvents(os.Stdout)
+		stdout = os.Stderr
+	}
+	return p
+}
+
+// stdout is where the messages and the results of jig are printed. When jig prints
+// JSON events, stdout is used for the events and everything else goes to stderr.
+var stdout io.Writer = os.Stdout
+
+// jsonEvents returns a function that writes every event as JSON on a line of its own.
+func jsonEvents(output io.Writer) func(pkg.Event) {
+	enc := json.NewEncoder(output)
+	enc.SetEscapeHTML(false)
+	return func(event pkg.Event) {
+		enc.Encode(event)
+	}
+}
+
+// printErrors prints the errors that jig could not fix to stderr, or emits them as events.
+func printErrors(p *pkg.Package, errors []error) {
+	if p.Events != nil {
+		p.EmitErrors(errors)
+		return
+	}
+	for _, err := range errors {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
+
+// genMain implements "jig gen", which is also run when no subcommand is given.
+func genMain(cmd *subcommand, args []string) int {
+	var (
+		f     genFlags
+		clean bool
+	)
+	flags := cmd.flagSet()
+	f.add(flags)
+	f.addDryRun(flags)
+	flags.BoolVarP(&f.missing, "missing", "m", false, "Only generate code that is missing")
+	flags.BoolVarP(&f.regen, "regen", "r", false, "Force regeneration of all code by jig (default)")
+	flags.BoolVarP(&clean, "clean", "c", false, "Remove files generated by jig")
+	flags.MarkDeprecated("clean", "use \"jig clean\" instead")
+	if ok, status := parse(flags, args); !ok {
+		return status
+	}
+	if f.missing && f.regen {
+		fmt.Fprintln(os.Stderr, "flags --missing and --regen cannot be used together")
+		return exitUsage
+	}
+
+	p, err := f.newPackage(dirArg(flags, 0), flags)
+	if printedError(f.verbose, nil, err) {
+		return exitStatus(err)
+	}
+	if clean {
+		return cleanPackage(p, f.verbose)
+	}
+	return f.generate(p, nil)
+}
+
+// instantiateMain implements "jig instantiate", which specializes the templates given as
+// arguments in the current directory, keeping the code generated before. The arguments
+// are joined into a single spec, so the template and the types may be given as separate
+// arguments e.g. jig instantiate '<Foo>Stack' Int, String
+func instantiateMain(cmd *subcommand, args []string) int {
+	var f genFlags
+	flags := cmd.flagSet()
+	f.add(flags)
+	f.addDryRun(flags)
+	if ok, status := parse(flags, args); !ok {
+		return status
+	}
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "instantiate needs at least one template to instantiate")
+		return exitUsage
+	}
+	spec := strings.Join(flags.Args(), " ")
+	if err := pkg.ValidateInstantiation(spec); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	f.missing = true
+
+	p, err := f.newPackage(".", flags)
+	if printedError(f.verbose, nil, err) {
+		return exitStatus(err)
+	}
+	return f.generate(p, []string{spec})
+}
+
+// checkMain implements "jig check", which generates the code for the package in
+// memory and reports the files that would change. The exit status is exitOutOfDate
+// when the generated code is not up to date and exitUnresolved when the package has
+// errors that jig could not fix.
+func checkMain(cmd *subcommand, args []string) int {
+	var (
+		f    genFlags
+		diff bool
+	)
+	flags := cmd.flagSet()
+	f.add(flags)
+	flags.BoolVarP(&f.missing, "missing", "m", false, "Only check for code that is missing")
+	flags.BoolVarP(&diff, "diff", "d", false, "Print a diff of the changes generating the code would make")
+	if ok, status := parse(flags, args); !ok {
+		return status
+	}
+	f.dryrun = true
+
+	p, err := f.newPackage(dirArg(flags, 0), flags)
+	if printedError(f.verbose, nil, err) {
+		return exitStatus(err)
+	}
+	errors, err := f.run(p, nil, nil)
+	if err != nil {
+		return exitStatus(err)
+	}
+	output := ioutil.Discard
+	if diff {
+		output = stdout
+	}
+	messages, err := p.DiffGeneratedSources(output)
+	if printedError(p.Events == nil, messages, err) {
+		return exitStatus(err)
+	}
+	printErrors(p, errors)
+	if len(errors) > 0 {
+		return exitUnresolved
+	}
+	if len(messages) > 0 {
+		return exitOutOfDate
+	}
+	if f.verbose {
+		fmt.Fprintln(stdout, "generated code is up to date")
+	}
+	return 0
+}
+
+// generate generates the code for the package, instantiating the given templates, and then writes
+// the generated source code files. Returns the exit status.
+func (f *genFlags) generate(p *pkg.Package, instantiate []string) int {
+	errors, err := f.run(p, nil, instantiate)
+	if err != nil {
+		return exitStatus(err)
+	}
+
+	if f.dryrun {
+		// Print what writing the generated source code file(s) would change.
+		messages, err := p.DiffGeneratedSources(stdout)
+		if printedError(f.verbose, messages, err) {
+			return exitStatus(err)
+		}
+	} else {
+		// Write the generated source code file(s)
+		messages, err := p.WriteGeneratedSources()
+		if printedError(f.verbose, messages, err) {
+			return exitStatus(err)
+		}
+	}
+
+	// Print unfixable errors from the last time Check() was called.
+	printErrors(p, errors)
+	if len(errors) > 0 {
+		return exitUnresolved
+	}
+	return 0
+}
+
+// run generates the code for the package in memory, instantiating the given templates. Unless only
+// missing code is generated, the code generated before is removed first. The templates are taken
+// from the cache when it has them for the package. Returns the errors that could not be fixed, or
+// the error that was printed when something went wrong.
+func (f *genFlags) run(p *pkg.Package, templates *templateCache, instantiate []string) ([]error, error) {
+	if f.verbose {
+		fmt.Fprintf(stdout, "jig built with %s\nGOROOT=%s\n", runtime.Version(), runtime.GOROOT())
+	}
+
+	if !f.missing {
+		// Clean the output directory by removing all generated source code file(s).
+		// Files are only removed from disk when the generated sources are written.
+		messages, err := p.RemoveGeneratedSources()
+		if printedError(f.verbose, messages, err) {
+			return nil, err
+		}
+	}
+
+	// Messages are printed as they come in verbose mode.
+	report := func(messages []string) {
+		printedError(f.verbose, messages, nil)
+	}
+	load := func() (templ.Specializer, error) {
+		if tplr := templates.lookup(p); tplr != nil {
+			return tplr, nil
+		}
+		// Look for our //jigs: comment pragmas and import
+		// any templates declared that way.
+		tplr := templ.NewSpecializer()
+		messages, err := p.LoadGenerics(tplr) // ~2ms
+		report(messages)
+		if err != nil {
+			return nil, err
+		}
+		templates.store(p, tplr)
+		return tplr, nil
+	}
+	errors, err := p.GenerateCode(instantiate, load, report)
+	if printedError(false, nil, err) {
+		return nil, err
+	}
+
+	if (f.prune || f.pruneExported) && len(errors) == 0 {
+		// Remove generated code that is no longer referenced.
+		messages, err := p.PruneGeneratedSources()
+		if printedError(f.verbose, messages, err) {
+			return nil, err
+		}
+	}
+	return errors, nil
+}
@@ -1,8 +1,14 @@
 module github.com/reactivego/jig
 
-go 1.16
+go 1.18
 
 require (
 	github.com/spf13/pflag v1.0.6
 	golang.org/x/tools v0.1.0
 )
+
+require (
+	golang.org/x/mod v0.3.0 // indirect
+	golang.org/x/sys v0.0.0-20210119212857-b64e53b001e4 // indirect
+	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
+)
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/reactivego/jig/templ"
+)
+
+// graphMain implements "jig graph", which prints the dependency graph of the templates
+// available to the package, or the graph instantiated for a signature, in DOT or JSON.
+func graphMain(cmd *subcommand, args []string) int {
+	var (
+		f         genFlags
+		signature string
+		format    string
+	)
+	flags := cmd.flagSet()
+	flags.StringVarP(&signature, "signature", "s", "", "Instantiate the graph for this signature e.g. 'IntStack Pop'")
+	flags.StringVarP(&format, "format", "f", "dot", "Format of the graph, either dot or json")
+	flags.BoolVarP(&f.verbose, "verbose", "v", false, "Print details of what jig is doing")
+	if ok, status := parse(flags, args); !ok {
+		return status
+	}
+	if format != "dot" && format != "json" {
+		fmt.Fprintf(os.Stderr, "unknown format %q, use dot or json\n", format)
+		return exitUsage
+	}
+
+	p, err := f.newPackage(dirArg(flags, 0), flags)
+	if printedError(f.verbose, nil, err) {
+		return exitStatus(err)
+	}
+	_, err = p.Check()
+	if printedError(f.verbose, nil, err) {
+		return exitStatus(err)
+	}
+	messages := p.LoadGeneratePragmas()
+	printedError(f.verbose, messages, nil)
+	tplr := templ.NewSpecializer()
+	messages, err = p.LoadGenerics(tplr)
+	if printedError(f.verbose, messages, err) {
+		return exitStatus(err)
+	}
+	graph, messages, err := tplr.Graph(p, signature)
+	if printedError(f.verbose, messages, err) {
+		return exitStatus(err)
+	}
+	if format == "json" {
+		err = graph.WriteJSON(os.Stdout)
+	} else {
+		err = graph.WriteDOT(os.Stdout)
+	}
+	if printedError(false, nil, err) {
+		return exitStatus(err)
+	}
+	return 0
+}
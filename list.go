@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+)
+
+// listMain implements "jig list", which lists the templates available to the package
+// or the source fragments generated into it.
+func listMain(cmd *subcommand, args []string) int {
+	var (
+		f         genFlags
+		generated bool
+	)
+	flags := cmd.flagSet()
+	flags.BoolVarP(&generated, "generated", "g", false, "List the source fragments generated by jig instead")
+	flags.StringVarP(&f.output, "output", "o", "", "Look for generated source fragments in a separate package in this directory")
+	flags.StringVar(&f.outputName, "pkg", "", "Name of the package in the output dir (default base name of output dir)")
+	flags.BoolVarP(&f.verbose, "verbose", "v", false, "Print details of what jig is doing")
+	if ok, status := parse(flags, args); !ok {
+		return status
+	}
+
+	p, err := f.newPackage(dirArg(flags, 0), flags)
+	if printedError(f.verbose, nil, err) {
+		return exitStatus(err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	defer w.Flush()
+	if generated {
+		sources := p.GeneratedSources()
+		var names []string
+		for name := range sources {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(w, "%s\t%s\n", name, sources[name])
+		}
+		return 0
+	}
+
+	_, err = p.Check()
+	if printedError(f.verbose, nil, err) {
+		return exitStatus(err)
+	}
+	messages := p.LoadGeneratePragmas()
+	printedError(f.verbose, messages, nil)
+	generics, messages, err := p.ListGenerics()
+	if printedError(f.verbose, messages, err) {
+		return exitStatus(err)
+	}
+	for _, generic := range generics {
+		fmt.Fprintf(w, "%s\t%s\n", generic.Name, generic.PackagePath)
+	}
+	return 0
+}
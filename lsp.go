@@ -0,0 +1,568 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf16"
+
+	"github.com/reactivego/jig/pkg"
+	"github.com/reactivego/jig/templ"
+
+	"github.com/spf13/pflag"
+)
+
+// lspMain implements "jig lsp", a language server that talks the Language Server Protocol
+// over stdin and stdout. It reports the code jig can generate for a package as diagnostics
+// and offers to generate it as quick fixes, taking the unsaved buffers of the editor into
+// account.
+func lspMain(cmd *subcommand, args []string) int {
+	var f genFlags
+	flags := cmd.flagSet()
+	flags.BoolVarP(&f.lines, "line-directives", "l", false, "Add //line directives pointing to the template sources")
+	flags.BoolVarP(&f.nodoc, "nodoc", "n", false, "No documentation in generated files")
+	flags.BoolVar(&f.tests, "with-tests", false, "Generate the tests of specialized templates into _test.go files")
+	flags.BoolVarP(&f.verbose, "verbose", "v", false, "Log details of what jig is doing to stderr")
+	if ok, status := parse(flags, args); !ok {
+		return status
+	}
+	// Stdout carries the protocol, print everything else to stderr.
+	stdout = os.Stderr
+	f.missing = true
+	f.dryrun = true
+
+	s := &server{
+		f:         f,
+		flags:     flags,
+		out:       os.Stdout,
+		buffers:   make(map[string][]byte),
+		published: make(map[string]map[string]struct{}),
+		pending:   make(map[string]*time.Timer),
+	}
+	if err := s.serve(os.Stdin); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	if !s.shutdown {
+		return exitError
+	}
+	return 0
+}
+
+// server is a language server for jig.
+type server struct {
+	f     genFlags
+	flags *pflag.FlagSet
+
+	// out is where responses and notifications are written, guarded by mu.
+	out io.Writer
+	mu  sync.Mutex
+
+	// buffers maps the paths of the open documents to their contents, guarded by mu.
+	buffers map[string][]byte
+	// published maps a package dir to the paths of the files diagnostics were published for, guarded by mu.
+	published map[string]map[string]struct{}
+	// pending maps a package dir to the timer that will analyze it, guarded by mu.
+	pending map[string]*time.Timer
+
+	// analyzing serializes the analysis of packages.
+	analyzing sync.Mutex
+
+	// shutdown is set when the client asked the server to shut down.
+	shutdown bool
+}
+
+// request is a JSON-RPC request or notification. A notification has no ID.
+type request struct {
+	ID     *json.RawMessage `json:"id,omitempty"`
+	Method string           `json:"method"`
+	Params json.RawMessage  `json:"params,omitempty"`
+}
+
+// response is a JSON-RPC response.
+type response struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Result  interface{}      `json:"result"`
+	Error   *responseError   `json:"error,omitempty"`
+}
+
+type responseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// notification is a JSON-RPC notification sent to the client.
+type notification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+type position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type textRange struct {
+	Start position `json:"start"`
+	End   position `json:"end"`
+}
+
+type diagnostic struct {
+	Range    textRange      `json:"range"`
+	Severity int            `json:"severity"`
+	Source   string         `json:"source"`
+	Message  string         `json:"message"`
+	Data     diagnosticData `json:"data"`
+}
+
+// diagnosticData is passed along with a diagnostic, so the code action for it knows what to generate.
+type diagnosticData struct {
+	Signature string `json:"signature"`
+}
+
+type textDocument struct {
+	URI  string `json:"uri"`
+	Text string `json:"text"`
+}
+
+type textEdit struct {
+	Range   textRange `json:"range"`
+	NewText string    `json:"newText"`
+}
+
+type codeAction struct {
+	Title       string        `json:"title"`
+	Kind        string        `json:"kind"`
+	Diagnostics []diagnostic  `json:"diagnostics,omitempty"`
+	Edit        workspaceEdit `json:"edit"`
+}
+
+type workspaceEdit struct {
+	DocumentChanges []interface{} `json:"documentChanges"`
+}
+
+type createFile struct {
+	Kind    string          `json:"kind"`
+	URI     string          `json:"uri"`
+	Options map[string]bool `json:"options"`
+}
+
+// documentIdentifier identifies a version of a document, a nil version is any version.
+type documentIdentifier struct {
+	URI     string `json:"uri"`
+	Version *int   `json:"version"`
+}
+
+type textDocumentEdit struct {
+	TextDocument documentIdentifier `json:"textDocument"`
+	Edits        []textEdit         `json:"edits"`
+}
+
+// LSP error codes.
+const (
+	methodNotFound = -32601
+	invalidParams  = -32602
+)
+
+// serve reads requests from input until the client exits.
+func (s *server) serve(input io.Reader) error {
+	in := bufio.NewReader(input)
+	for {
+		req, err := readRequest(in)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if req.Method == "exit" {
+			return nil
+		}
+		result, rerr := s.handle(req)
+		if req.ID != nil {
+			s.write(response{JSONRPC: "2.0", ID: req.ID, Result: result, Error: rerr})
+		}
+	}
+}
+
+// readRequest reads a request with its Content-Length header.
+func readRequest(in *bufio.Reader) (*request, error) {
+	length := -1
+	for {
+		line, err := in.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(strings.ToLower(line), "content-length:") {
+			length, err = strconv.Atoi(strings.TrimSpace(line[len("content-length:"):]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid header %q", line)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("missing Content-Length header")
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(in, body); err != nil {
+		return nil, err
+	}
+	req := &request{}
+	if err := json.Unmarshal(body, req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// write writes a response or a notification with its Content-Length header.
+func (s *server) write(msg interface{}) {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+// handle handles a request and returns its result.
+func (s *server) handle(req *request) (interface{}, *responseError) {
+	switch req.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				// Full text document sync.
+				"textDocumentSync":   1,
+				"codeActionProvider": true,
+			},
+			"serverInfo": map[string]string{"name": "jig"},
+		}, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen", "textDocument/didChange", "textDocument/didSave", "textDocument/didClose":
+		var params struct {
+			TextDocument   textDocument `json:"textDocument"`
+			ContentChanges []struct {
+				Text string `json:"text"`
+			} `json:"contentChanges"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, &responseError{invalidParams, err.Error()}
+		}
+		path := uriPath(params.TextDocument.URI)
+		if filepath.Ext(path) != ".go" {
+			return nil, nil
+		}
+		s.mu.Lock()
+		switch req.Method {
+		case "textDocument/didOpen":
+			s.buffers[path] = []byte(params.TextDocument.Text)
+		case "textDocument/didChange":
+			if n := len(params.ContentChanges); n > 0 {
+				s.buffers[path] = []byte(params.ContentChanges[n-1].Text)
+			}
+		case "textDocument/didClose":
+			delete(s.buffers, path)
+		}
+		s.mu.Unlock()
+		s.schedule(filepath.Dir(path))
+		return nil, nil
+	case "textDocument/codeAction":
+		var params struct {
+			TextDocument textDocument `json:"textDocument"`
+			Context      struct {
+				Diagnostics []diagnostic `json:"diagnostics"`
+			} `json:"context"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, &responseError{invalidParams, err.Error()}
+		}
+		return s.codeActions(filepath.Dir(uriPath(params.TextDocument.URI)), params.Context.Diagnostics), nil
+	}
+	if req.ID != nil {
+		return nil, &responseError{methodNotFound, fmt.Sprintf("method %q not supported", req.Method)}
+	}
+	return nil, nil
+}
+
+// schedule analyzes the package in dir once its documents have been left unchanged for a moment.
+func (s *server) schedule(dir string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if timer, present := s.pending[dir]; present {
+		timer.Stop()
+	}
+	s.pending[dir] = time.AfterFunc(300*time.Millisecond, func() {
+		s.analyze(dir)
+	})
+}
+
+// load loads the package in dir with the contents of the open documents, and loads the
+// templates available to it. Returns the signatures suggested by the type checking errors
+// and the events that give their positions, mapped to the signatures.
+func (s *server) load(dir string) (*pkg.Package, templ.Specializer, []string, map[string]pkg.Event, error) {
+	p, err := s.newPackage(dir)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	events := make(map[string]pkg.Event)
+	p.Events = func(event pkg.Event) {
+		if event.Event == pkg.SignatureSuggested {
+			events[event.Signature] = event
+		}
+	}
+	errors, err := p.Check()
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	messages := p.LoadGeneratePragmas()
+	printedError(s.f.verbose, messages, nil)
+	tplr := templ.NewSpecializer()
+	messages, err = p.LoadGenerics(tplr)
+	if printedError(s.f.verbose, messages, nil) || err != nil {
+		return nil, nil, nil, nil, err
+	}
+	return p, tplr, p.SuggestTypesToGenerate(errors), events, nil
+}
+
+// newPackage creates the package for dir, with the contents of the open documents in its
+// overlay, and parses the files in the package dir.
+func (s *server) newPackage(dir string) (*pkg.Package, error) {
+	p := s.f.packageFor(dir, s.flags)
+	p.Overlay = make(map[string][]byte)
+	s.mu.Lock()
+	for path, contents := range s.buffers {
+		p.Overlay[path] = contents
+	}
+	s.mu.Unlock()
+	return p, p.ParseDir()
+}
+
+// contents returns the contents of the open document with the path, or of the file on disk.
+func (s *server) contents(path string) []byte {
+	s.mu.Lock()
+	contents, present := s.buffers[path]
+	s.mu.Unlock()
+	if !present {
+		contents, _ = ioutil.ReadFile(path)
+	}
+	return contents
+}
+
+// analyze publishes a diagnostic for every signature in the package in dir that jig can
+// generate code for.
+func (s *server) analyze(dir string) {
+	s.analyzing.Lock()
+	defer s.analyzing.Unlock()
+
+	var diagnostics = make(map[string][]diagnostic)
+	p, tplr, signatures, events, err := s.load(dir)
+	if printedError(s.f.verbose, nil, err) {
+		return
+	}
+	for _, signature := range signatures {
+		recorder := &fragmentRecorder{Package: p}
+		_, err := tplr.GenerateCodeForType(recorder, signature)
+		if printedError(false, nil, err) || len(recorder.fragments) == 0 {
+			continue
+		}
+		event := events[signature]
+		if event.File == "" {
+			continue
+		}
+		path, _ := filepath.Abs(event.File)
+		diagnostics[path] = append(diagnostics[path], diagnostic{
+			Range:    wordRange(s.contents(path), event.Line-1, event.Column-1),
+			Severity: 3, // Information
+			Source:   "jig",
+			Message:  fmt.Sprintf("jig can generate %q: %s", signature, strings.Join(recorder.fragments, ", ")),
+			Data:     diagnosticData{Signature: signature},
+		})
+	}
+
+	// Clear the diagnostics published before for files that no longer have any.
+	s.mu.Lock()
+	previous := s.published[dir]
+	s.published[dir] = make(map[string]struct{})
+	for path := range diagnostics {
+		s.published[dir][path] = struct{}{}
+	}
+	s.mu.Unlock()
+	for path := range previous {
+		if _, present := diagnostics[path]; !present {
+			diagnostics[path] = []diagnostic{}
+		}
+	}
+	var paths []string
+	for path := range diagnostics {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		s.write(notification{JSONRPC: "2.0", Method: "textDocument/publishDiagnostics", Params: map[string]interface{}{
+			"uri":         pathURI(path),
+			"diagnostics": diagnostics[path],
+		}})
+	}
+}
+
+// codeActions returns a quick fix for every jig diagnostic, that generates the code for
+// its signature, and a source action that generates all the code missing from the package.
+func (s *server) codeActions(dir string, diagnostics []diagnostic) []codeAction {
+	s.analyzing.Lock()
+	defer s.analyzing.Unlock()
+
+	actions := []codeAction{}
+	for _, d := range diagnostics {
+		if d.Source != "jig" || d.Data.Signature == "" {
+			continue
+		}
+		p, tplr, _, _, err := s.load(dir)
+		if printedError(s.f.verbose, nil, err) {
+			return actions
+		}
+		messages, err := tplr.GenerateCodeForType(p, d.Data.Signature)
+		if printedError(s.f.verbose, messages, err) {
+			continue
+		}
+		if edit, ok := s.workspaceEdit(p); ok {
+			actions = append(actions, codeAction{
+				Title:       fmt.Sprintf("Generate %s with jig", d.Data.Signature),
+				Kind:        "quickfix",
+				Diagnostics: []diagnostic{d},
+				Edit:        edit,
+			})
+		}
+	}
+
+	s.mu.Lock()
+	found := len(s.published[dir]) > 0
+	s.mu.Unlock()
+	if found {
+		p, err := s.newPackage(dir)
+		if printedError(s.f.verbose, nil, err) {
+			return actions
+		}
+		if _, err := s.f.run(p, nil, nil); err == nil {
+			if edit, ok := s.workspaceEdit(p); ok {
+				actions = append(actions, codeAction{
+					Title: "Generate all missing code with jig",
+					Kind:  "source",
+					Edit:  edit,
+				})
+			}
+		}
+	}
+	return actions
+}
+
+// workspaceEdit returns the edit that writes the generated sources of the package. Returns
+// false when nothing would change.
+func (s *server) workspaceEdit(p *pkg.Package) (workspaceEdit, bool) {
+	files, err := p.ChangedFiles()
+	if printedError(s.f.verbose, nil, err) || len(files) == 0 {
+		return workspaceEdit{}, false
+	}
+	var paths []string
+	for path, contents := range files {
+		// Generating missing code never removes files.
+		if contents != nil {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	edit := workspaceEdit{DocumentChanges: []interface{}{}}
+	for _, path := range paths {
+		path, _ := filepath.Abs(path)
+		previous := s.contents(path)
+		if previous == nil {
+			edit.DocumentChanges = append(edit.DocumentChanges, createFile{Kind: "create", URI: pathURI(path), Options: map[string]bool{"ignoreIfExists": true}})
+		}
+		// Replace the whole document, the end of the range lies beyond the last line.
+		end := position{Line: bytes.Count(previous, []byte("\n")) + 1}
+		edit.DocumentChanges = append(edit.DocumentChanges, textDocumentEdit{
+			TextDocument: documentIdentifier{URI: pathURI(path)},
+			Edits:        []textEdit{{Range: textRange{End: end}, NewText: string(files[path])}},
+		})
+	}
+	return edit, true
+}
+
+// fragmentRecorder is a templ.PackageWriter that records the names of the fragments generated
+// instead of adding them to the package.
+type fragmentRecorder struct {
+	*pkg.Package
+	fragments []string
+}
+
+func (r *fragmentRecorder) HasGeneratedSource(name string) bool {
+	for _, fragment := range r.fragments {
+		if fragment == name {
+			return true
+		}
+	}
+	return r.Package.HasGeneratedSource(name)
+}
+
+func (r *fragmentRecorder) GenerateSource(fragment templ.Fragment) error {
+	if !fragment.Test || r.WithTests {
+		r.fragments = append(r.fragments, fragment.Name)
+	}
+	return nil
+}
+
+// wordRange returns the range of the identifier at the byte offset col of the line in the
+// contents. The protocol counts the characters of a position in UTF-16 code units.
+func wordRange(contents []byte, line, col int) textRange {
+	lines := strings.Split(string(contents), "\n")
+	if line < 0 || line >= len(lines) || col < 0 || col > len(lines[line]) {
+		return textRange{position{line, col}, position{line, col}}
+	}
+	text := lines[line]
+	word := strings.IndexFunc(text[col:], func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+	if word < 0 {
+		word = len(text) - col
+	}
+	start := position{line, utf16Len(text[:col])}
+	return textRange{start, position{line, start.Character + utf16Len(text[col:col+word])}}
+}
+
+// utf16Len returns the number of UTF-16 code units needed to encode the string.
+func utf16Len(s string) int {
+	return len(utf16.Encode([]rune(s)))
+}
+
+// uriPath returns the path of a file URI e.g. "file:///home/gopher/stack.go" -> "/home/gopher/stack.go"
+func uriPath(uri string) string {
+	if u, err := url.Parse(uri); err == nil && u.Scheme == "file" {
+		return filepath.FromSlash(u.Path)
+	}
+	return uri
+}
+
+// pathURI returns the file URI of a path e.g. "/home/gopher/stack.go" -> "file:///home/gopher/stack.go"
+func pathURI(path string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
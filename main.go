@@ -2,152 +2,244 @@
 jig is a tool for generating Go source code from a generics library.
 
 	$ go get github.com/reactivego/jig
-	$ jig -h
-	Usage of jig [flags] [<dir>]:
-	-c, --clean     Remove files generated by jig
-	-m, --missing   Only generate code that is missing
-	-r, --regen     Force regeneration of all code by jig (default)
-	-v, --verbose   Print details of what jig is doing
+	$ jig help
+	Usage: jig [<command>] [flags] [<args>]
+
+	Commands:
+	  gen           Generate the code missing from the package (default)
+	  clean         Remove the files generated by jig
+	  list          List the templates available to the package
+	  explain       Explain how a signature is resolved to templates
+	  graph         Print the dependency graph of the templates
+	  watch         Generate the code again whenever source or templates change
+	  lsp           Serve the Language Server Protocol, offering generated code as quick fixes
+	  check         Check that the generated code is up to date
+	  instantiate   Specialize templates for the given types
+	  migrate       Convert templates to Go code with type parameters
+
+	Without a command jig runs gen, so "jig -m" is the same as "jig gen -m".
+	Run "jig help <command>" for the flags of a command.
+
+	Exit status:
+	  0  success
+	  1  other error
+	  2  invalid command-line arguments
+	  3  the package or a library could not be loaded
+	  4  a template is malformed
+	  5  a generated file could not be written
+	  6  the package has errors that jig could not fix
+	  7  the generated code is not up to date (check)
+
+Diagnostics are printed to stderr.
 
 For details see https://github.com/reactivego/jig/
 */
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
-	"runtime"
+	"strings"
 
 	"github.com/reactivego/jig/pkg"
 	"github.com/reactivego/jig/templ"
-
 	"github.com/spf13/pflag"
 )
 
+// Exit status of jig, so scripts can tell why jig failed.
+const (
+	exitOK = iota
+	// exitError is the exit status for errors that have no category of their own.
+	exitError
+	// exitUsage is the exit status for invalid command-line arguments.
+	exitUsage
+	// exitLoad is the exit status when the package or a library cannot be loaded.
+	exitLoad
+	// exitTemplate is the exit status when a template is malformed.
+	exitTemplate
+	// exitWrite is the exit status when a generated file cannot be written.
+	exitWrite
+	// exitUnresolved is the exit status when the package has errors jig cannot fix.
+	exitUnresolved
+	// exitOutOfDate is the exit status of "jig check" when the generated code is not up to date.
+	exitOutOfDate
+)
+
 func main() {
-	os.Exit(jigMain())
+	os.Exit(jigMain(os.Args[1:]))
 }
 
-func jigMain() int {
-	// Flag handling...
-	var clean, forceregen, missing, verbose, nodoc bool
-	pflag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage of %s [flags] [<dir>]:\n", os.Args[0])
-		pflag.PrintDefaults()
-	}
-	pflag.BoolVarP(&clean, "clean", "c", false, "Remove files generated by jig")
-	pflag.BoolVarP(&forceregen, "regen", "r", false, "Force regeneration of all code by jig (default)")
-	pflag.BoolVarP(&missing, "missing", "m", false, "Only generate code that is missing")
-	pflag.BoolVarP(&verbose, "verbose", "v", false, "Print details of what jig is doing")
-	pflag.BoolVarP(&nodoc, "nodoc", "n", false, "No documentation in generated files")
-	pflag.Parse()
-
-	if forceregen && missing {
-		missing = false
-	}
-
-	if verbose {
-		fmt.Printf("jig built with %s\nGOROOT=%s\n", runtime.Version(), runtime.GOROOT())
-
-	}
-
-	// If no dir has been given, use current directory.
-	dir := pflag.Arg(0)
-	if dir == "" {
-		dir = "."
-	}
+// subcommand is a command of jig e.g. "gen" in "jig gen -m".
+type subcommand struct {
+	// name of the subcommand e.g. "gen"
+	name string
+	// args contains the forms of the arguments e.g. ["[<dir>]"]
+	args []string
+	// short describes the subcommand in a single line.
+	short string
+	// run runs the subcommand with the arguments following its name and
+	// returns the exit status.
+	run func(cmd *subcommand, args []string) int
+}
 
-	// Create a package that will read and write files from the given dir.
-	pkg := pkg.NewPackage(dir)
-	pkg.Nodoc = nodoc
+// commands contains the subcommands of jig in the order in which they are listed.
+var commands []*subcommand
 
-	// Parse all files currently in the package directory.
-	err := pkg.ParseDir()
-	if printedError(verbose, nil, err) {
-		return 1
+func init() {
+	commands = []*subcommand{
+		{name: "gen", args: []string{"[<dir>]"}, short: "Generate the code missing from the package (default)", run: genMain},
+		{name: "clean", args: []string{"[<dir>]"}, short: "Remove the files generated by jig", run: cleanMain},
+		{name: "list", args: []string{"[<dir>]"}, short: "List the templates available to the package", run: listMain},
+		{name: "explain", args: []string{"<identifier> [<dir>]"}, short: "Explain how a signature is resolved to templates", run: explainMain},
+		{name: "graph", args: []string{"[<dir>]"}, short: "Print the dependency graph of the templates", run: graphMain},
+		{name: "watch", args: []string{"[<dir>]"}, short: "Generate the code again whenever source or templates change", run: watchMain},
+		{name: "lsp", args: []string{""}, short: "Serve the Language Server Protocol, offering generated code as quick fixes", run: lspMain},
+		{name: "check", args: []string{"[<dir>]"}, short: "Check that the generated code is up to date", run: checkMain},
+		{name: "instantiate", args: []string{"<template> <types>[, <types>]", "[<name> =] <template>[<types>]"}, short: "Specialize templates for the given types", run: instantiateMain},
+		{name: "migrate", args: []string{"[<dir>]"}, short: "Convert templates to Go code with type parameters", run: migrateMain},
 	}
+}
 
-	if clean || !missing {
-		// Clean the output directory by removing all generated source code file(s)
-		messages, err := pkg.RemoveGeneratedSources()
-		if printedError(verbose, messages, err) {
-			return 1
+// jigMain runs the subcommand named by the first argument. When the first
+// argument does not name a subcommand, the gen command is run.
+func jigMain(args []string) int {
+	if len(args) > 0 && args[0] == "help" {
+		if len(args) > 1 {
+			if cmd := lookup(args[1]); cmd != nil {
+				return cmd.run(cmd, []string{"--help"})
+			}
+			fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[1])
+			usage()
+			return exitUsage
 		}
-		if clean {
-			return 0
+		usage()
+		return exitOK
+	}
+	if len(args) > 0 {
+		if cmd := lookup(args[0]); cmd != nil {
+			return cmd.run(cmd, args[1:])
 		}
 	}
+	gen := lookup("gen")
+	return gen.run(gen, args)
+}
 
-	write := true
-
-	var (
-		errors []error
-		tplr   templ.Specializer
-	)
-
-	// As long as files are being generated we are still fixing code.
-	for generating := write; generating; {
-		generating = false
-
-		errors, err = pkg.Check() // ~ 410ms
-		if printedError(verbose, nil, err) {
-			return 1
+// lookup returns the subcommand with the given name or nil when there is none.
+func lookup(name string) *subcommand {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
 		}
+	}
+	return nil
+}
 
-		if len(errors) == 0 {
-			break
-		}
+// usage prints the list of subcommands.
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: jig [<command>] [flags] [<args>]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-13s %s\n", cmd.name, cmd.short)
+	}
+	fmt.Fprintf(os.Stderr, "\nWithout a command jig runs gen, so \"jig -m\" is the same as \"jig gen -m\".\n")
+	fmt.Fprintf(os.Stderr, "Run \"jig help <command>\" for the flags of a command.\n")
+	fmt.Fprintf(os.Stderr, "\nExit status:\n")
+	for _, status := range exitStatuses {
+		fmt.Fprintf(os.Stderr, "  %d  %s\n", status.code, status.short)
+	}
+}
 
-		// Look in the files directly associated with the package for
-		// comment pragmas jig:file and jig:type.
-		messages := pkg.LoadGeneratePragmas()
-		if printedError(verbose, messages, nil) {
-			return 1
-		}
+// exitStatuses describes the exit status of jig in the order in which they are listed.
+var exitStatuses = []struct {
+	code  int
+	short string
+}{
+	{exitOK, "success"},
+	{exitError, "other error"},
+	{exitUsage, "invalid command-line arguments"},
+	{exitLoad, "the package or a library could not be loaded"},
+	{exitTemplate, "a template is malformed"},
+	{exitWrite, "a generated file could not be written"},
+	{exitUnresolved, "the package has errors that jig could not fix"},
+	{exitOutOfDate, "the generated code is not up to date (check)"},
+}
 
-		if tplr == nil {
-			// Look for our //jigs: comment pragmas and import
-			// any templates declared that way.
-			tplr = templ.NewSpecializer()
-			messages, err := pkg.LoadGenerics(tplr) // ~2ms
-			if printedError(verbose, messages, err) {
-				return 1
+// flagSet returns a new flag set for the subcommand, with a usage function that
+// prints the arguments and the flags of the subcommand.
+func (cmd *subcommand) flagSet() *pflag.FlagSet {
+	flags := pflag.NewFlagSet("jig "+cmd.name, pflag.ContinueOnError)
+	flags.Usage = func() {
+		for i, args := range cmd.args {
+			if i == 0 {
+				fmt.Fprintf(os.Stderr, "Usage: jig %s [flags] %s\n", cmd.name, args)
+			} else {
+				fmt.Fprintf(os.Stderr, "       jig %s [flags] %s\n", cmd.name, args)
 			}
 		}
-
-		// Implement missing language constructs.
-		for _, sig := range pkg.SuggestTypesToGenerate(errors) {
-			messages, err := tplr.GenerateCodeForType(pkg, sig)
-			if printedError(verbose, messages, err) {
-				return 1
-			}
-			generating = generating || len(messages) > 0
+		fmt.Fprintf(os.Stderr, "\n%s\n", cmd.short)
+		if flags.HasAvailableFlags() {
+			fmt.Fprintf(os.Stderr, "\nFlags:\n%s", flags.FlagUsages())
+		}
+		if cmd.name == "gen" {
+			fmt.Fprintln(os.Stderr)
+			usage()
 		}
 	}
+	return flags
+}
 
-	if write {
-		// Write the generated source code file(s)
-		messages, err := pkg.WriteGeneratedSources()
-		if printedError(verbose, messages, err) {
-			return 1
+// parse parses the arguments of the subcommand with the flag set. It returns
+// false when the subcommand should not run, e.g. when help was requested, with
+// the exit status to return in that case.
+func parse(flags *pflag.FlagSet, args []string) (ok bool, status int) {
+	if err := flags.Parse(args); err != nil {
+		if err == pflag.ErrHelp {
+			return false, 0
 		}
+		return false, exitUsage
 	}
+	return true, 0
+}
 
-	// Print unfixable errors from the last time Check() was called.
-	for _, err := range errors {
-		fmt.Println(err)
-	}
-	if len(errors) > 0 {
-		return 1
+// dirArg returns the directory given as argument at index i, defaulting to the
+// current directory.
+func dirArg(flags *pflag.FlagSet, i int) string {
+	if dir := flags.Arg(i); dir != "" {
+		return dir
 	}
+	return "."
+}
 
-	return 0
+// command returns the jig command with the flags that influence code generation,
+// as they were passed on the command-line. This command is written in the
+// go:generate directive of generated files.
+func command(flags *pflag.FlagSet) string {
+	cmd := []string{"jig"}
+	flags.Visit(func(flag *pflag.Flag) {
+		switch flag.Name {
+		case "clean", "dry-run", "diff", "verbose", "json", "output", "pkg", "interval", "delay":
+			return
+		}
+		arg := "--" + flag.Name
+		if flag.Shorthand != "" {
+			arg = "-" + flag.Shorthand
+		}
+		if flag.Value.Type() != "bool" {
+			arg += "=" + flag.Value.String()
+		} else if flag.Value.String() != "true" {
+			return
+		}
+		cmd = append(cmd, arg)
+	})
+	return strings.Join(cmd, " ")
 }
 
+// printedError prints the messages when verbose and then the error, if any, to stderr.
+// Returns true when an error was printed.
 func printedError(verbose bool, messages []string, err error) bool {
 	if verbose {
 		for _, msg := range messages {
-			fmt.Println(msg)
+			fmt.Fprintln(stdout, msg)
 		}
 	}
 	if err == nil {
@@ -156,3 +248,23 @@ func printedError(verbose bool, messages []string, err error) bool {
 	fmt.Fprintln(os.Stderr, err)
 	return true
 }
+
+// exitStatus returns the exit status for the error, depending on its category.
+func exitStatus(err error) int {
+	var (
+		loadErr     *pkg.LoadError
+		templateErr *templ.TemplateError
+		writeErr    *pkg.WriteError
+	)
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &loadErr):
+		return exitLoad
+	case errors.As(err, &templateErr):
+		return exitTemplate
+	case errors.As(err, &writeErr):
+		return exitWrite
+	}
+	return exitError
+}
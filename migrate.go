@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// migrateMain implements "jig migrate", which converts the templates in the dir
+// to Go code with type parameters.
+func migrateMain(cmd *subcommand, args []string) int {
+	var f genFlags
+	flags := cmd.flagSet()
+	flags.StringVarP(&f.output, "output", "o", "", "Write the converted code to a package in this directory")
+	flags.StringVar(&f.outputName, "pkg", "", "Name of the package to write the converted code to (default base name of output dir)")
+	flags.BoolVarP(&f.verbose, "verbose", "v", false, "Print details of what jig is doing")
+	if ok, status := parse(flags, args); !ok {
+		return status
+	}
+
+	p, err := f.newPackage(dirArg(flags, 0), flags)
+	if printedError(f.verbose, nil, err) {
+		return exitStatus(err)
+	}
+	_, err = p.Check()
+	if printedError(f.verbose, nil, err) {
+		return exitStatus(err)
+	}
+	source, report, err := p.MigrateGenerics()
+	if printedError(f.verbose, nil, err) {
+		return exitStatus(err)
+	}
+	// Without an output dir the source goes to stdout and the report to stderr.
+	reportTo := os.Stderr
+	if f.output == "" {
+		os.Stdout.Write(source)
+	} else {
+		reportTo = os.Stdout
+		path := filepath.Join(f.output, p.OutputName+".go")
+		if err := os.MkdirAll(f.output, 0755); printedError(f.verbose, nil, err) {
+			return exitStatus(err)
+		}
+		if err := ioutil.WriteFile(path, source, 0644); printedError(f.verbose, nil, err) {
+			return exitStatus(err)
+		}
+		report = append(report, fmt.Sprintf("wrote file %q", path))
+	}
+	for _, line := range report {
+		fmt.Fprintln(reportTo, line)
+	}
+	return 0
+}
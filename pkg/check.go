@@ -1,5 +1,11 @@
 package pkg
 
+import (
+	"go/types"
+	"sort"
+	"strings"
+)
+
 // Check will typecheck the currently parsed package source and return all errors
 // that were found. This will also import and parse all dependencies.
 // After Check() has finished the package contains the contents of all imported
@@ -13,20 +19,44 @@ func (p *Package) Check() ([]error, error) {
 	// Load the program, type checking it in the process.
 	prog, err := p.Load()
 	if err != nil {
-		return nil, err
+		return nil, &LoadError{Dir: p.Dir, Err: err}
 	}
 
 	// Collect all errors that were found into a single slice.
 	var errs []error
+	p.info = nil
+	p.interfaceMethods = nil
 	for _, created := range prog.Created {
 		errs = append(errs, created.Errors...)
+		p.info = created
+	}
+	if output, present := prog.Imported[p.outputPath]; present && p.OutputDir != "" {
+		errs = append(errs, output.Errors...)
+	}
+
+	// A package that cannot be imported is not something jig can fix.
+	for _, err := range errs {
+		if err, ok := err.(types.Error); ok && strings.HasPrefix(err.Msg, "could not import ") {
+			return nil, &LoadError{Dir: p.Dir, Err: err}
+		}
 	}
 
-	// Append all PackageInfo structs into allPackages.
+	// Append all PackageInfo structs into allPackages, sorted by path.
 	p.allPackages = nil
 	for _, pkg := range prog.AllPackages {
 		p.allPackages = append(p.allPackages, pkg)
 	}
+	sort.Slice(p.allPackages, func(i, j int) bool {
+		return p.allPackages[i].Pkg.Path() < p.allPackages[j].Pkg.Path()
+	})
+
+	if p.Events != nil {
+		path, err := importPath(p.Dir)
+		if err != nil {
+			path = p.Dir
+		}
+		p.emit(Event{Event: PackageLoaded, Package: path, File: p.Dir})
+	}
 
 	//fmt.Println("Check", time.Since(d))
 	return errs, nil
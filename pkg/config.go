@@ -0,0 +1,351 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// configName is the name of the project configuration file. It is found by walking up from
+// the package dir to the module root, the nearest one is used.
+const configName = "jig.yaml"
+
+// projectConfig contains the defaults for the generator pragmas given by a jig.yaml file.
+// The keys in the file are the names of the pragmas e.g.
+//
+//	layout: type
+//	max-lines: 2000
+//	header: |
+//	  Copyright 2021 The Authors. All rights reserved.
+//	type:
+//	  Woot: woot
+//	prefer:
+//	  - github.com/reactivego/rx/generic
+//	no-doc: true
+//
+// Only this subset of YAML is supported: scalars, literal block scalars and a single level
+// of maps and lists. Comments start with a '#'.
+type projectConfig struct {
+	// path of the jig.yaml file.
+	path string
+
+	file, layout, header, headerFile string
+	maxLines                         int
+	typemap                          map[string]string
+	prefer, keep                     []string
+	nodoc, forceCommon               bool
+}
+
+// findProjectConfig returns the path of the jig.yaml file nearest to the package dir, walking
+// up to the module root i.e. the dir containing the go.mod file. Returns an empty path when
+// there is none.
+func (p *Package) findProjectConfig() string {
+	dir, err := filepath.Abs(p.Dir)
+	if err != nil {
+		return ""
+	}
+	for {
+		path := filepath.Join(dir, configName)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return ""
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// loadProjectConfig reads and parses the jig.yaml file of the package. Returns nil when
+// the package has no jig.yaml file.
+func (p *Package) loadProjectConfig() (*projectConfig, error) {
+	path := p.findProjectConfig()
+	if path == "" {
+		return nil, nil
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseProjectConfig(path, content)
+}
+
+// parseProjectConfig parses the content of the jig.yaml file at path.
+func parseProjectConfig(path string, content []byte) (*projectConfig, error) {
+	config := &projectConfig{path: path, typemap: make(map[string]string)}
+	values, err := parseYAML(content)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%v", path, err)
+	}
+	for _, v := range values {
+		invalid := func(format string, args ...interface{}) error {
+			return fmt.Errorf("%s:%d: %s: %s", path, v.line, v.key, fmt.Sprintf(format, args...))
+		}
+		switch v.key {
+		case "file", "layout", "header", "header-file", "max-lines", "no-doc", "force-common-code-generation":
+			if v.scalar == nil {
+				return nil, invalid("value must be a scalar")
+			}
+		case "type":
+			if v.mapping == nil {
+				return nil, invalid("value must be a map of display types to real types")
+			}
+		case "prefer", "keep":
+			if v.list == nil {
+				return nil, invalid("value must be a list")
+			}
+		default:
+			return nil, invalid("unknown key")
+		}
+		switch v.key {
+		case "file":
+			config.file = *v.scalar
+		case "layout":
+			if _, present := layouts[*v.scalar]; !present {
+				return nil, invalid("unknown layout %q", *v.scalar)
+			}
+			config.layout = *v.scalar
+		case "header":
+			config.header = strings.TrimRight(*v.scalar, "\n")
+		case "header-file":
+			config.headerFile = filepath.Join(filepath.Dir(path), *v.scalar)
+		case "max-lines":
+			if config.maxLines, err = strconv.Atoi(*v.scalar); err != nil {
+				return nil, invalid("%v", err)
+			}
+		case "no-doc", "force-common-code-generation":
+			b, err := strconv.ParseBool(*v.scalar)
+			if err != nil {
+				return nil, invalid("%v", err)
+			}
+			if v.key == "no-doc" {
+				config.nodoc = b
+			} else {
+				config.forceCommon = b
+			}
+		case "type":
+			for _, entry := range v.mapping {
+				config.typemap[entry[0]] = entry[1]
+			}
+		case "prefer":
+			config.prefer = v.list
+		case "keep":
+			config.keep = v.list
+		}
+	}
+	return config, nil
+}
+
+// applyDefaults sets the defaults given by the jig.yaml file, before the pragmas of the
+// package are loaded so they can override them.
+func (c *projectConfig) applyDefaults(p *Package) error {
+	if c.file != "" {
+		filename, err := template.New("filename").Parse(c.file)
+		if err != nil {
+			return fmt.Errorf("%s: file: %v", c.path, err)
+		}
+		p.filename = filename
+	}
+	if c.layout != "" {
+		p.filename = template.Must(template.New("filename").Parse(layouts[c.layout]))
+	}
+	if c.maxLines != 0 {
+		p.maxLines = c.maxLines
+	}
+	header := c.header
+	if c.headerFile != "" {
+		content, err := ioutil.ReadFile(c.headerFile)
+		if err != nil {
+			return fmt.Errorf("%s: header-file: %v", c.path, err)
+		}
+		header = string(content)
+	}
+	if header != "" {
+		tpl, err := template.New("header").Parse(header)
+		if err != nil {
+			return fmt.Errorf("%s: header: %v", c.path, err)
+		}
+		p.header = tpl
+	}
+	for display, real := range c.typemap {
+		if _, present := p.typemap[display]; !present {
+			p.typemap[display] = real
+		}
+	}
+	for _, pattern := range c.keep {
+		p.addKeep(pattern)
+	}
+	p.Nodoc = p.Nodoc || c.nodoc
+	p.forceCommon = p.forceCommon || c.forceCommon
+	return nil
+}
+
+// yamlValue is a top level key of a YAML document with either a scalar, list or map value.
+type yamlValue struct {
+	key     string
+	line    int
+	scalar  *string
+	list    []string
+	mapping [][2]string
+}
+
+// parseYAML parses the subset of YAML used by jig.yaml files. Every top level key has either
+// a scalar value, a literal block scalar (|), a list of scalars or a map of scalars.
+func parseYAML(content []byte) ([]*yamlValue, error) {
+	var (
+		values  []*yamlValue
+		current *yamlValue
+		block   *strings.Builder
+		indent  int
+	)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		trimmed := strings.TrimLeft(line, " ")
+		depth := len(line) - len(trimmed)
+		if block != nil {
+			if trimmed == "" {
+				block.WriteString("\n")
+				continue
+			}
+			if indent == 0 {
+				indent = depth
+			}
+			if depth >= indent && indent > 0 {
+				block.WriteString(line[indent:] + "\n")
+				continue
+			}
+			text := strings.TrimRight(block.String(), "\n") + "\n"
+			current.scalar = &text
+			block = nil
+		}
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("%d: tabs are not allowed for indentation", n)
+		}
+		if depth == 0 {
+			key, value, ok := splitKeyValue(trimmed)
+			if !ok {
+				return nil, fmt.Errorf("%d: expected key: value", n)
+			}
+			for _, v := range values {
+				if v.key == key {
+					return nil, fmt.Errorf("%d: duplicate key %q", n, key)
+				}
+			}
+			current = &yamlValue{key: key, line: n}
+			values = append(values, current)
+			switch value {
+			case "":
+			case "|":
+				block, indent = &strings.Builder{}, 0
+			default:
+				scalar, err := unquoteYAML(value)
+				if err != nil {
+					return nil, fmt.Errorf("%d: %v", n, err)
+				}
+				current.scalar = &scalar
+			}
+			continue
+		}
+		if current == nil || current.scalar != nil {
+			return nil, fmt.Errorf("%d: unexpected indentation", n)
+		}
+		if strings.HasPrefix(trimmed, "- ") || trimmed == "-" {
+			if current.mapping != nil {
+				return nil, fmt.Errorf("%d: expected key: value", n)
+			}
+			item, err := unquoteYAML(strings.TrimSpace(strings.TrimPrefix(trimmed, "-")))
+			if err != nil {
+				return nil, fmt.Errorf("%d: %v", n, err)
+			}
+			current.list = append(current.list, item)
+			continue
+		}
+		key, value, ok := splitKeyValue(trimmed)
+		if !ok || current.list != nil {
+			return nil, fmt.Errorf("%d: expected - item", n)
+		}
+		value, err := unquoteYAML(value)
+		if err != nil {
+			return nil, fmt.Errorf("%d: %v", n, err)
+		}
+		current.mapping = append(current.mapping, [2]string{key, value})
+	}
+	if block != nil {
+		text := strings.TrimRight(block.String(), "\n") + "\n"
+		current.scalar = &text
+	}
+	return values, scanner.Err()
+}
+
+// splitKeyValue splits a line of the form "key: value" with any comment removed.
+func splitKeyValue(line string) (key, value string, ok bool) {
+	i := strings.Index(line, ":")
+	if i <= 0 || (i+1 < len(line) && line[i+1] != ' ') {
+		return "", "", false
+	}
+	return strings.TrimSpace(line[:i]), strings.TrimSpace(stripComment(line[i+1:])), true
+}
+
+// stripComment removes a comment, starting with " #", from a value. The comment of a
+// quoted value starts after the closing quote.
+func stripComment(value string) string {
+	start := len(value) - len(strings.TrimLeft(value, " "))
+	if end := closingQuote(value[start:]); end > 0 {
+		start += end
+	} else if end < 0 {
+		return value
+	}
+	if i := strings.Index(value[start:], " #"); i >= 0 {
+		return value[:start+i]
+	}
+	return value
+}
+
+// closingQuote returns the offset just past the closing quote of a single or double quoted
+// value, 0 when the value is not quoted and -1 when the closing quote is missing.
+func closingQuote(value string) int {
+	if value == "" || (value[0] != '"' && value[0] != '\'') {
+		return 0
+	}
+	quote := value[0]
+	for i := 1; i < len(value); i++ {
+		switch {
+		case quote == '"' && value[i] == '\\':
+			i++
+		case quote == '\'' && value[i] == '\'' && i+1 < len(value) && value[i+1] == '\'':
+			i++
+		case value[i] == quote:
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// unquoteYAML returns the value of a plain, single quoted or double quoted scalar.
+func unquoteYAML(value string) (string, error) {
+	value = strings.TrimSpace(stripComment(value))
+	switch {
+	case strings.HasPrefix(value, `"`):
+		return strconv.Unquote(value)
+	case strings.HasPrefix(value, `'`):
+		if len(value) < 2 || !strings.HasSuffix(value, `'`) {
+			return "", fmt.Errorf("unterminated string %s", value)
+		}
+		return strings.Replace(value[1:len(value)-1], "''", "'", -1), nil
+	}
+	return value, nil
+}
@@ -0,0 +1,204 @@
+package pkg
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// yamlString returns the values as text, one value per line e.g. `1:layout=scalar "type"`.
+func yamlString(values []*yamlValue) string {
+	var lines []string
+	for _, v := range values {
+		switch {
+		case v.scalar != nil:
+			lines = append(lines, fmt.Sprintf("%d:%s=scalar %q", v.line, v.key, *v.scalar))
+		case v.list != nil:
+			lines = append(lines, fmt.Sprintf("%d:%s=list %q", v.line, v.key, v.list))
+		case v.mapping != nil:
+			lines = append(lines, fmt.Sprintf("%d:%s=map %q", v.line, v.key, v.mapping))
+		default:
+			lines = append(lines, fmt.Sprintf("%d:%s=empty", v.line, v.key))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		name   string
+		yaml   string
+		values string
+		err    string
+	}{{
+		name:   "scalars",
+		yaml:   "layout: type\nmax-lines: 2000\n\nno-doc: true\n",
+		values: "1:layout=scalar \"type\"\n2:max-lines=scalar \"2000\"\n4:no-doc=scalar \"true\"",
+	}, {
+		name:   "empty value",
+		yaml:   "keep:\n",
+		values: "1:keep=empty",
+	}, {
+		name:   "quoted scalars",
+		yaml:   "a: \"x: \\\"y\\\"\"\nb: 'it''s'\nc: \"#1\"\nd: '#2'\n",
+		values: "1:a=scalar \"x: \\\"y\\\"\"\n2:b=scalar \"it's\"\n3:c=scalar \"#1\"\n4:d=scalar \"#2\"",
+	}, {
+		name:   "comments",
+		yaml:   "# jig settings\nlayout: type # one file per type\n  # indented comment\nfile: a#b.go\n",
+		values: "2:layout=scalar \"type\"\n4:file=scalar \"a#b.go\"",
+	}, {
+		name:   "comments after quoted scalars",
+		yaml:   "header: \"x\" # note\nfile: 'y # z' # note\nkeep:\n  - \"a\\\"\" # note\n",
+		values: "1:header=scalar \"x\"\n2:file=scalar \"y # z\"\n3:keep=list [\"a\\\"\"]",
+	}, {
+		name:   "block scalar",
+		yaml:   "header: |\n  Copyright\n\n    indented\nlayout: type\n",
+		values: "1:header=scalar \"Copyright\\n\\n  indented\\n\"\n5:layout=scalar \"type\"",
+	}, {
+		name:   "block scalar at end",
+		yaml:   "header: | # banner\n  line 1\n  line 2\n\n",
+		values: "1:header=scalar \"line 1\\nline 2\\n\"",
+	}, {
+		name:   "list",
+		yaml:   "prefer:\n  - github.com/reactivego/rx/generic\n  - 'quoted'\n",
+		values: "1:prefer=list [\"github.com/reactivego/rx/generic\" \"quoted\"]",
+	}, {
+		name:   "map",
+		yaml:   "type:\n  Woot: woot\n  Point: \"struct{ x, y int }\"\n",
+		values: "1:type=map [[\"Woot\" \"woot\"] [\"Point\" \"struct{ x, y int }\"]]",
+	}, {
+		name: "missing key",
+		yaml: "layout: type\njust text\n",
+		err:  "2: expected key: value",
+	}, {
+		name: "missing space after colon",
+		yaml: "layout:type\n",
+		err:  "1: expected key: value",
+	}, {
+		name: "duplicate key",
+		yaml: "layout: type\n\nlayout: single\n",
+		err:  "3: duplicate key \"layout\"",
+	}, {
+		name: "tab indentation",
+		yaml: "prefer:\n\t- x\n",
+		err:  "2: tabs are not allowed for indentation",
+	}, {
+		name: "indentation after scalar",
+		yaml: "layout: type\n  - x\n",
+		err:  "2: unexpected indentation",
+	}, {
+		name: "indentation before key",
+		yaml: "  - x\n",
+		err:  "1: unexpected indentation",
+	}, {
+		name: "map entry in list",
+		yaml: "prefer:\n  - x\n  y: z\n",
+		err:  "3: expected - item",
+	}, {
+		name: "list item in map",
+		yaml: "type:\n  x: y\n  - z\n",
+		err:  "3: expected key: value",
+	}, {
+		name: "unterminated single quote",
+		yaml: "file: 'x\n",
+		err:  "1: unterminated string 'x",
+	}, {
+		name: "unterminated double quote",
+		yaml: "keep:\n  - \"x # y\n",
+		err:  "2: invalid syntax",
+	}, {
+		name: "text after quoted scalar",
+		yaml: "file: \"x\" y\n",
+		err:  "1: invalid syntax",
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			values, err := parseYAML([]byte(test.yaml))
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Errorf("got error %v; expected %s", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if yamlString(values) != test.values {
+				t.Errorf("got values\n%s\nexpected\n%s", yamlString(values), test.values)
+			}
+		})
+	}
+}
+
+func TestParseProjectConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		yaml   string
+		config string
+		err    string
+	}{{
+		name: "all keys",
+		yaml: "file: gen.go\nlayout: type\nheader: |\n  Banner\nheader-file: header.txt\nmax-lines: 2000\n" +
+			"type:\n  Woot: woot\nprefer:\n  - lib\nkeep:\n  - Int*\nno-doc: true\nforce-common-code-generation: false\n",
+		config: `file="gen.go" layout="type" header="Banner" headerFile="dir/header.txt" maxLines=2000 typemap=map[Woot:woot] prefer=["lib"] keep=["Int*"] nodoc=true forceCommon=false`,
+	}, {
+		name:   "defaults",
+		yaml:   "# nothing set\n",
+		config: `file="" layout="" header="" headerFile="" maxLines=0 typemap=map[] prefer=[] keep=[] nodoc=false forceCommon=false`,
+	}, {
+		name:   "some keys",
+		yaml:   "layout: package # one file\nforce-common-code-generation: true\n",
+		config: `file="" layout="package" header="" headerFile="" maxLines=0 typemap=map[] prefer=[] keep=[] nodoc=false forceCommon=true`,
+	}, {
+		name: "syntax error",
+		yaml: "layout: type\nlayout: package\n",
+		err:  "dir/jig.yaml:2: duplicate key \"layout\"",
+	}, {
+		name: "unknown key",
+		yaml: "layout: type\nprune: true\n",
+		err:  "dir/jig.yaml:2: prune: unknown key",
+	}, {
+		name: "unknown layout",
+		yaml: "\nlayout: huge\n",
+		err:  "dir/jig.yaml:2: layout: unknown layout \"huge\"",
+	}, {
+		name: "scalar expected",
+		yaml: "max-lines:\n  - 10\n",
+		err:  "dir/jig.yaml:1: max-lines: value must be a scalar",
+	}, {
+		name: "map expected",
+		yaml: "type: woot\n",
+		err:  "dir/jig.yaml:1: type: value must be a map of display types to real types",
+	}, {
+		name: "list expected",
+		yaml: "prefer: lib\n",
+		err:  "dir/jig.yaml:1: prefer: value must be a list",
+	}, {
+		name: "invalid number",
+		yaml: "max-lines: many\n",
+		err:  "dir/jig.yaml:1: max-lines: strconv.Atoi: parsing \"many\": invalid syntax",
+	}, {
+		name: "invalid bool",
+		yaml: "no-doc: false\nforce-common-code-generation: yes\n",
+		err:  "dir/jig.yaml:2: force-common-code-generation: strconv.ParseBool: parsing \"yes\": invalid syntax",
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config, err := parseProjectConfig("dir/jig.yaml", []byte(test.yaml))
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Errorf("got error %v; expected %s", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := fmt.Sprintf("file=%q layout=%q header=%q headerFile=%q maxLines=%d typemap=%v prefer=%q keep=%q nodoc=%v forceCommon=%v",
+				config.file, config.layout, config.header, config.headerFile, config.maxLines, config.typemap, config.prefer, config.keep, config.nodoc, config.forceCommon)
+			if got != test.config {
+				t.Errorf("got config\n%s\nexpected\n%s", got, test.config)
+			}
+		})
+	}
+}
@@ -0,0 +1,319 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// DiffGeneratedSources writes a unified diff to output for every file with
+// generated source that would be created, changed or removed when writing the
+// generated sources. Files in the package dir are compared with the generated
+// sources in memory, nothing is written to disk.
+func (p *Package) DiffGeneratedSources(output io.Writer) (messages []string, err error) {
+	paths, current, err := p.generatedContents()
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		previous, err := p.previousContents(path)
+		if err != nil {
+			return messages, err
+		}
+		source, generated := current[path]
+		if string(previous) == source {
+			continue
+		}
+		from, to := "a/"+path, "b/"+path
+		switch {
+		case previous == nil:
+			from = "/dev/null"
+			messages = append(messages, p.report(Event{Event: FileWritten, File: path, DryRun: true, Created: true}))
+		case !generated:
+			to = "/dev/null"
+			messages = append(messages, p.report(Event{Event: FileRemoved, File: path, DryRun: true}))
+		default:
+			messages = append(messages, p.report(Event{Event: FileWritten, File: path, DryRun: true}))
+		}
+		fmt.Fprintf(output, "--- %s\n+++ %s\n", from, to)
+		writeUnifiedDiff(output, splitLines(string(previous)), splitLines(source))
+	}
+	return messages, nil
+}
+
+// ChangedFiles returns the contents that writing the generated sources would give the
+// files that change, mapped to their paths. A file that would be removed maps to nil.
+func (p *Package) ChangedFiles() (map[string][]byte, error) {
+	paths, current, err := p.generatedContents()
+	if err != nil {
+		return nil, err
+	}
+	changed := make(map[string][]byte)
+	for _, path := range paths {
+		previous, err := p.previousContents(path)
+		if err != nil {
+			return nil, err
+		}
+		source, generated := current[path]
+		if string(previous) == source {
+			continue
+		}
+		if generated {
+			changed[path] = []byte(source)
+		} else {
+			changed[path] = nil
+		}
+	}
+	return changed, nil
+}
+
+// generatedContents returns the sorted paths of the files that contain generated source,
+// either on disk or in memory, and of the files rewritten to refer to the output package.
+// The current contents of the files in memory are returned mapped to their paths.
+func (p *Package) generatedContents() ([]string, map[string]string, error) {
+	if err := p.SortGeneratedSources(); err != nil {
+		return nil, nil, err
+	}
+	paths := make(map[string]struct{})
+	for path := range p.disk {
+		paths[path] = struct{}{}
+	}
+	current := make(map[string]string)
+	fileset := p.GeneratedFileset()
+	for path := range p.rewritten {
+		fileset[p.fileset[path]] = struct{}{}
+		paths[path] = struct{}{}
+	}
+	for file := range fileset {
+		var source bytes.Buffer
+		if err := p.WriteFile(&source, file); err != nil {
+			return nil, nil, err
+		}
+		path := p.Filepath(file)
+		current[path] = source.String()
+		paths[path] = struct{}{}
+	}
+	var sorted []string
+	for path := range paths {
+		sorted = append(sorted, path)
+	}
+	sort.Strings(sorted)
+	return sorted, current, nil
+}
+
+// previousContents returns the contents on disk of a file with generated source, or nil
+// when the file does not exist yet.
+func (p *Package) previousContents(path string) ([]byte, error) {
+	_, rewritten := p.rewritten[path]
+	if _, present := p.disk[path]; present || rewritten {
+		previous, err := ioutil.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		return previous, nil
+	}
+	return nil, nil
+}
+
+// splitLines splits text into lines, every line including its line ending.
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// edit is a single line of an edit script that turns one list of lines into another.
+type edit struct {
+	op   byte // ' ', '-' or '+'
+	line string
+}
+
+// diffLines returns the shortest edit script turning a into b using the linear
+// space variant of the algorithm described in "An O(ND) Difference Algorithm and
+// Its Variations" by Eugene W. Myers. The lines a and b start and end with are
+// matched before searching, so the lines of a file that is created or removed
+// are listed without searching at all.
+func diffLines(a, b []string) []edit {
+	size := len(a) + len(b) + 5
+	d := &differ{a: a, b: b, forward: make([]int, size), backward: make([]int, size)}
+	d.diff(0, len(a), 0, len(b))
+	return d.edits
+}
+
+// differ finds the edit script turning a into b.
+type differ struct {
+	a, b []string
+	// forward and backward contain the furthest reaching x on every diagonal
+	// for the paths searched from the start and from the end of the lines.
+	forward, backward []int
+	edits             []edit
+}
+
+// diff appends the edit script turning a[x0:x1] into b[y0:y1] to the edits.
+func (d *differ) diff(x0, x1, y0, y1 int) {
+	for x0 < x1 && y0 < y1 && d.a[x0] == d.b[y0] {
+		d.edits = append(d.edits, edit{' ', d.a[x0]})
+		x0++
+		y0++
+	}
+	suffix := x1
+	for x1 > x0 && y1 > y0 && d.a[x1-1] == d.b[y1-1] {
+		x1--
+		y1--
+	}
+	switch {
+	case x0 == x1:
+		for ; y0 < y1; y0++ {
+			d.edits = append(d.edits, edit{'+', d.b[y0]})
+		}
+	case y0 == y1:
+		for ; x0 < x1; x0++ {
+			d.edits = append(d.edits, edit{'-', d.a[x0]})
+		}
+	default:
+		// Both the lines before and the lines after the middle snake
+		// take fewer edits than all lines, so this always ends.
+		x, y, u, v := d.middleSnake(x0, x1, y0, y1)
+		d.diff(x0, x, y0, y)
+		for ; x < u; x++ {
+			d.edits = append(d.edits, edit{' ', d.a[x]})
+		}
+		d.diff(u, x1, v, y1)
+	}
+	for ; x1 < suffix; x1++ {
+		d.edits = append(d.edits, edit{' ', d.a[x1]})
+	}
+}
+
+// middleSnake searches for the shortest edit script turning a[x0:x1] into b[y0:y1]
+// from both ends at the same time, until the paths overlap. Returns the start (x, y)
+// and the end (u, v) of the diagonal on which they meet, which is part of the script.
+func (d *differ) middleSnake(x0, x1, y0, y1 int) (x, y, u, v int) {
+	n, m := x1-x0, y1-y0
+	delta := n - m
+	odd := delta%2 != 0
+	offset := (n+m+1)/2 + 1
+	// The forward path is searched from (x0, y0) on diagonals k = x - y and the
+	// backward path from (x1, y1) on diagonals k = x - y counted from the end,
+	// so backward diagonal k is forward diagonal delta - k.
+	d.forward[offset+1], d.backward[offset+1] = 0, 0
+	for D := 0; D <= offset-1; D++ {
+		for k := -D; k <= D; k += 2 {
+			var x int
+			if k == -D || (k != D && d.forward[offset+k-1] < d.forward[offset+k+1]) {
+				x = d.forward[offset+k+1]
+			} else {
+				x = d.forward[offset+k-1] + 1
+			}
+			y := x - k
+			sx, sy := x, y
+			for x < n && y < m && d.a[x0+x] == d.b[y0+y] {
+				x++
+				y++
+			}
+			d.forward[offset+k] = x
+			if b := delta - k; odd && b >= -(D-1) && b <= D-1 && x+d.backward[offset+b] >= n {
+				return x0 + sx, y0 + sy, x0 + x, y0 + y
+			}
+		}
+		for k := -D; k <= D; k += 2 {
+			var x int
+			if k == -D || (k != D && d.backward[offset+k-1] < d.backward[offset+k+1]) {
+				x = d.backward[offset+k+1]
+			} else {
+				x = d.backward[offset+k-1] + 1
+			}
+			y := x - k
+			sx, sy := x, y
+			for x < n && y < m && d.a[x1-1-x] == d.b[y1-1-y] {
+				x++
+				y++
+			}
+			d.backward[offset+k] = x
+			if f := delta - k; !odd && f >= -D && f <= D && x+d.forward[offset+f] >= n {
+				return x1 - x, y1 - y, x1 - sx, y1 - sy
+			}
+		}
+	}
+	panic("diff: paths do not overlap")
+}
+
+// writeUnifiedDiff writes the hunks of a unified diff turning a into b with
+// three lines of context around every change.
+func writeUnifiedDiff(output io.Writer, a, b []string) {
+	const context = 3
+	edits := diffLines(a, b)
+	for start := 0; start < len(edits); {
+		// Find the next change.
+		for start < len(edits) && edits[start].op == ' ' {
+			start++
+		}
+		if start == len(edits) {
+			break
+		}
+		// Extend the hunk until there are more than 2*context unchanged lines.
+		end := start
+		for unchanged := 0; end < len(edits) && unchanged <= 2*context; end++ {
+			if edits[end].op == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+		}
+		for end > start && edits[end-1].op == ' ' {
+			end--
+		}
+		first := start - context
+		if first < 0 {
+			first = 0
+		}
+		last := end + context
+		if last > len(edits) {
+			last = len(edits)
+		}
+
+		// Line numbers in a and b at the start of the hunk.
+		aline, bline := 1, 1
+		for _, e := range edits[:first] {
+			if e.op != '+' {
+				aline++
+			}
+			if e.op != '-' {
+				bline++
+			}
+		}
+		var hunk bytes.Buffer
+		acount, bcount := 0, 0
+		for _, e := range edits[first:last] {
+			if e.op != '+' {
+				acount++
+			}
+			if e.op != '-' {
+				bcount++
+			}
+			hunk.WriteByte(e.op)
+			hunk.WriteString(e.line)
+			if !strings.HasSuffix(e.line, "\n") {
+				hunk.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		if acount == 0 {
+			aline--
+		}
+		if bcount == 0 {
+			bline--
+		}
+		fmt.Fprintf(output, "@@ -%d,%d +%d,%d @@\n", aline, acount, bline, bcount)
+		hunk.WriteTo(output)
+		start = last
+	}
+}
@@ -0,0 +1,232 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// numbered returns the lines "1\n" up to and including "n\n", with the lines at the
+// given indices replaced.
+func numbered(n int, replace map[int]string) string {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		if line, present := replace[i]; present {
+			b.WriteString(line)
+			continue
+		}
+		fmt.Fprintf(&b, "%d\n", i)
+	}
+	return b.String()
+}
+
+func TestSplitLines(t *testing.T) {
+	tests := []struct {
+		text  string
+		lines []string
+	}{
+		{"", nil},
+		{"a", []string{"a"}},
+		{"a\n", []string{"a\n"}},
+		{"a\nb", []string{"a\n", "b"}},
+		{"a\n\nb\n", []string{"a\n", "\n", "b\n"}},
+	}
+	for _, test := range tests {
+		lines := splitLines(test.text)
+		if fmt.Sprintf("%q", lines) != fmt.Sprintf("%q", test.lines) {
+			t.Errorf("splitLines(%q) = %q; expected %q", test.text, lines, test.lines)
+		}
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		a, b string
+		ops  string
+	}{
+		{"", "", ""},
+		{"a\n", "a\n", " "},
+		{"", "a\nb\n", "++"},
+		{"a\nb\n", "", "--"},
+		{"a\nc\n", "a\nb\nc\n", " + "},
+		{"a\nb\nc\n", "a\nc\n", " - "},
+		{"a\nb\nc\n", "a\nx\nc\n", " -+ "},
+		{"a\nb", "a\nb\n", " -+"},
+	}
+	for _, test := range tests {
+		a, b := splitLines(test.a), splitLines(test.b)
+		edits := diffLines(a, b)
+		var ops []byte
+		var from, to strings.Builder
+		for _, e := range edits {
+			ops = append(ops, e.op)
+			if e.op != '+' {
+				from.WriteString(e.line)
+			}
+			if e.op != '-' {
+				to.WriteString(e.line)
+			}
+		}
+		if string(ops) != test.ops {
+			t.Errorf("diffLines(%q, %q) ops = %q; expected %q", test.a, test.b, ops, test.ops)
+		}
+		if from.String() != test.a || to.String() != test.b {
+			t.Errorf("diffLines(%q, %q) turns %q into %q", test.a, test.b, from.String(), to.String())
+		}
+	}
+}
+
+// applyEdits checks that the edits turn a into b and returns the number of lines
+// added and removed.
+func applyEdits(t *testing.T, a, b []string, edits []edit) int {
+	t.Helper()
+	var from, to []string
+	changes := 0
+	for _, e := range edits {
+		if e.op != '+' {
+			from = append(from, e.line)
+		}
+		if e.op != '-' {
+			to = append(to, e.line)
+		}
+		if e.op != ' ' {
+			changes++
+		}
+	}
+	if strings.Join(from, "") != strings.Join(a, "") || strings.Join(to, "") != strings.Join(b, "") {
+		t.Fatalf("diffLines(%q, %q) turns %q into %q", a, b, from, to)
+	}
+	return changes
+}
+
+func TestDiffLinesShortest(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	lines := func() []string {
+		var lines []string
+		for i := rnd.Intn(12); i > 0; i-- {
+			lines = append(lines, string(rune('a'+rnd.Intn(4)))+"\n")
+		}
+		return lines
+	}
+	for i := 0; i < 2000; i++ {
+		a, b := lines(), lines()
+		// The length of the longest common subsequence gives the shortest edit script.
+		lcs := make([][]int, len(a)+1)
+		for x := range lcs {
+			lcs[x] = make([]int, len(b)+1)
+		}
+		for x := len(a) - 1; x >= 0; x-- {
+			for y := len(b) - 1; y >= 0; y-- {
+				if a[x] == b[y] {
+					lcs[x][y] = lcs[x+1][y+1] + 1
+				} else if lcs[x+1][y] > lcs[x][y+1] {
+					lcs[x][y] = lcs[x+1][y]
+				} else {
+					lcs[x][y] = lcs[x][y+1]
+				}
+			}
+		}
+		changes := applyEdits(t, a, b, diffLines(a, b))
+		if shortest := len(a) + len(b) - 2*lcs[0][0]; changes != shortest {
+			t.Fatalf("diffLines(%q, %q) makes %d changes; expected %d", a, b, changes, shortest)
+		}
+	}
+}
+
+func TestDiffLinesLarge(t *testing.T) {
+	large := splitLines(numbered(8000, nil))
+	changed := splitLines(numbered(8000, map[int]string{10: "x\n", 4000: "", 7990: "y\n"}))
+	tests := []struct {
+		name    string
+		a, b    []string
+		changes int
+	}{
+		{"created", nil, large, 8000},
+		{"removed", large, nil, 8000},
+		{"changed", large, changed, 5},
+		{"replaced", large, splitLines(strings.Repeat("x\n", 8000)), 16000},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			edits := diffLines(test.a, test.b)
+			runtime.ReadMemStats(&after)
+			if changes := applyEdits(t, test.a, test.b, edits); changes != test.changes {
+				t.Errorf("diffLines makes %d changes; expected %d", changes, test.changes)
+			}
+			// The edits take most of the memory, the search itself takes space linear in the number of lines.
+			if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 8<<20 {
+				t.Errorf("diffLines allocated %d bytes", allocated)
+			}
+		})
+	}
+}
+
+func TestWriteUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		diff string
+	}{{
+		name: "empty",
+		a:    "",
+		b:    "",
+		diff: "",
+	}, {
+		name: "unchanged",
+		a:    numbered(3, nil),
+		b:    numbered(3, nil),
+		diff: "",
+	}, {
+		name: "create",
+		a:    "",
+		b:    "a\nb\n",
+		diff: "@@ -0,0 +1,2 @@\n+a\n+b\n",
+	}, {
+		name: "remove",
+		a:    "a\nb\n",
+		b:    "",
+		diff: "@@ -1,2 +0,0 @@\n-a\n-b\n",
+	}, {
+		name: "insert",
+		a:    numbered(10, nil),
+		b:    numbered(10, map[int]string{5: "5\nx\n"}),
+		diff: "@@ -3,6 +3,7 @@\n 3\n 4\n 5\n+x\n 6\n 7\n 8\n",
+	}, {
+		name: "delete",
+		a:    numbered(10, nil),
+		b:    numbered(10, map[int]string{5: ""}),
+		diff: "@@ -2,7 +2,6 @@\n 2\n 3\n 4\n-5\n 6\n 7\n 8\n",
+	}, {
+		name: "insert at start",
+		a:    numbered(5, nil),
+		b:    "0\n" + numbered(5, nil),
+		diff: "@@ -1,3 +1,4 @@\n+0\n 1\n 2\n 3\n",
+	}, {
+		name: "missing newline at end",
+		a:    "a\nb",
+		b:    "a\nb\n",
+		diff: "@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n",
+	}, {
+		name: "merged hunks",
+		a:    numbered(20, nil),
+		b:    numbered(20, map[int]string{5: "x\n", 12: "y\n"}),
+		diff: "@@ -2,14 +2,14 @@\n 2\n 3\n 4\n-5\n+x\n 6\n 7\n 8\n 9\n 10\n 11\n-12\n+y\n 13\n 14\n 15\n",
+	}, {
+		name: "separate hunks",
+		a:    numbered(20, nil),
+		b:    numbered(20, map[int]string{5: "x\n", 13: "y\n"}),
+		diff: "@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+x\n 6\n 7\n 8\n@@ -10,7 +10,7 @@\n 10\n 11\n 12\n-13\n+y\n 14\n 15\n 16\n",
+	}}
+	for _, test := range tests {
+		var diff bytes.Buffer
+		writeUnifiedDiff(&diff, splitLines(test.a), splitLines(test.b))
+		if diff.String() != test.diff {
+			t.Errorf("%s: got diff\n%s\nexpected\n%s", test.name, diff.String(), test.diff)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package pkg
+
+import (
+	"fmt"
+	"os"
+)
+
+// LoadError is returned when the package cannot be loaded, e.g. when a file in the
+// package dir does not parse or when an imported package cannot be found.
+type LoadError struct {
+	// Dir is the package dir.
+	Dir string
+	// Err is the error encountered while loading the package.
+	Err error
+}
+
+func (e *LoadError) Error() string {
+	return fmt.Sprintf("cannot load package in %q: %v", e.Dir, e.Err)
+}
+
+func (e *LoadError) Unwrap() error {
+	return e.Err
+}
+
+// WriteError is returned when a file with generated source cannot be written to
+// or removed from disk.
+type WriteError struct {
+	// Path is the path of the file.
+	Path string
+	// Err is the error encountered while writing or removing the file.
+	Err error
+}
+
+func (e *WriteError) Error() string {
+	return fmt.Sprintf("cannot write %q: %v", e.Path, e.Err)
+}
+
+func (e *WriteError) Unwrap() error {
+	return e.Err
+}
+
+// writeError returns err as a WriteError for the file with the given path. The path
+// in errors of the os package is left out, it is that of a temporary file anyway.
+func writeError(path string, err error) error {
+	switch e := err.(type) {
+	case *os.PathError:
+		err = e.Err
+	case *os.LinkError:
+		err = e.Err
+	}
+	return &WriteError{Path: path, Err: err}
+}
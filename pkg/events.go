@@ -0,0 +1,127 @@
+package pkg
+
+import (
+	"fmt"
+	"go/scanner"
+	"go/token"
+	"go/types"
+)
+
+// Kinds of events passed to the Events function of a package.
+const (
+	// PackageLoaded is emitted every time the package has been loaded and type checked.
+	PackageLoaded = "package-loaded"
+	// TemplateFound is emitted for every template found in the package and the packages it imports.
+	TemplateFound = "template-found"
+	// SignatureSuggested is emitted for every signature of missing code suggested by a type checking error.
+	SignatureSuggested = "signature-suggested"
+	// FragmentGenerated is emitted for every source fragment generated by specializing a template.
+	FragmentGenerated = "fragment-generated"
+	// FileWritten is emitted for every file with generated source that is written.
+	FileWritten = "file-written"
+	// FileRemoved is emitted for every file that no longer contains generated source and is removed.
+	FileRemoved = "file-removed"
+	// UnresolvedError is emitted for every type checking error that jig could not fix.
+	UnresolvedError = "unresolved-error"
+)
+
+// Event is a structured record of something jig did while generating code for the package.
+// Only the fields relevant to the kind of event are set.
+type Event struct {
+	// Event is the kind of event.
+	// e.g. "fragment-generated"
+	Event string `json:"event"`
+	// Package is the import path of the package loaded, or of the package the template was found in.
+	// e.g. "github.com/reactivego/rx/generic"
+	Package string `json:"package,omitempty"`
+	// Template is the name of the template found or specialized.
+	// e.g. "Observable<Foo> Map<Bar>"
+	Template string `json:"template,omitempty"`
+	// Test is set when the template found or the fragment generated contains tests.
+	Test bool `json:"test,omitempty"`
+	// Signature is the signature of the missing code suggested by an error.
+	// e.g. "ObservableInt MapString"
+	Signature string `json:"signature,omitempty"`
+	// Fragment is the name of the source fragment generated.
+	// e.g. "ObservableInt_MapString"
+	Fragment string `json:"fragment,omitempty"`
+	// Types contains the types bound to the template vars of the template specialized.
+	// e.g. ["Int", "String"]
+	Types []string `json:"types,omitempty"`
+	// File is the path of the file written or removed, the file the fragment is generated
+	// into, the file of the template found or of the error, or the dir of the package loaded.
+	File string `json:"file,omitempty"`
+	// Line and Column are the position of the template found or of the error in File.
+	Line   int `json:"line,omitempty"`
+	Column int `json:"column,omitempty"`
+	// Message is the message of the error.
+	Message string `json:"message,omitempty"`
+	// DryRun is set when the file would be written or removed, but the package is doing a dry run.
+	DryRun bool `json:"dryRun,omitempty"`
+	// Created is set when the file written did not exist before.
+	Created bool `json:"created,omitempty"`
+}
+
+// String returns the message that reports the event when printing what jig is doing,
+// e.g. "writing file \"stack.go\"". Returns an empty string for kinds of events
+// that are not reported one by one.
+func (e Event) String() string {
+	switch {
+	case e.Event == FileWritten && e.DryRun && e.Created:
+		return fmt.Sprintf("would create file %q", e.File)
+	case e.Event == FileWritten && e.DryRun:
+		return fmt.Sprintf("would change file %q", e.File)
+	case e.Event == FileWritten:
+		return fmt.Sprintf("writing file %q", e.File)
+	case e.Event == FileRemoved && e.DryRun:
+		return fmt.Sprintf("would remove file %q", e.File)
+	case e.Event == FileRemoved:
+		return fmt.Sprintf("removing file %q", e.File)
+	}
+	return ""
+}
+
+// emit passes the event to the Events function of the package, when there is one.
+func (p *Package) emit(event Event) {
+	if p.Events != nil {
+		p.Events(event)
+	}
+}
+
+// report emits the event and returns the message that reports it, so the messages
+// of the package and its events never tell a different story.
+func (p *Package) report(event Event) string {
+	p.emit(event)
+	return event.String()
+}
+
+// emitPosition sets the position of the event to pos and then emits it.
+func (p *Package) emitPosition(event Event, pos token.Position) {
+	event.File, event.Line, event.Column = pos.Filename, pos.Line, pos.Column
+	p.emit(event)
+}
+
+// EmitErrors emits an UnresolvedError event for every error, with the position of
+// the error in the source when it is known.
+func (p *Package) EmitErrors(errs []error) {
+	for _, err := range errs {
+		pos, msg := p.errorPosition(err)
+		p.emitPosition(Event{Event: UnresolvedError, Message: msg}, pos)
+	}
+}
+
+// errorPosition returns the position and the message of an error found while loading
+// and type checking the package.
+func (p *Package) errorPosition(err error) (token.Position, string) {
+	switch err := err.(type) {
+	case types.Error:
+		return err.Fset.Position(err.Pos), err.Msg
+	case scanner.Error:
+		return err.Pos, err.Msg
+	case scanner.ErrorList:
+		if len(err) > 0 {
+			return err[0].Pos, err[0].Msg
+		}
+	}
+	return token.Position{}, err.Error()
+}
@@ -0,0 +1,101 @@
+package pkg
+
+import (
+	"github.com/reactivego/jig/templ"
+)
+
+// GenerateCode generates the code for the package in memory. As long as code is being
+// generated, the package is checked again and the code for the errors that were found is
+// generated. On the first pass the templates requested by jig:instantiate pragmas and the
+// given specs are specialized and the tests missing for code generated before are added.
+// The templates are returned by load, which is only called once there is code to generate.
+// When load is nil, the templates are loaded from the packages imported by the package.
+// The messages of every step are passed to report, when it is not nil. Returns the errors
+// that could not be fixed.
+func (p *Package) GenerateCode(instantiate []string, load func() (templ.Specializer, error), report func([]string)) ([]error, error) {
+	if load == nil {
+		load = func() (templ.Specializer, error) {
+			tplr := templ.NewSpecializer()
+			messages, err := p.LoadGenerics(tplr)
+			if report != nil {
+				report(messages)
+			}
+			return tplr, err
+		}
+	}
+	if report == nil {
+		report = func([]string) {}
+	}
+
+	var (
+		errs []error
+		tplr templ.Specializer
+	)
+
+	// Without anything to instantiate or tests to add, a package without errors is up to date.
+	firstPass := len(instantiate) > 0 || p.HasInstantiatePragmas() || p.WithTests
+
+	// As long as files are being generated we are still fixing code.
+	for generating := true; generating; {
+		generating = false
+
+		var err error
+		errs, err = p.Check() // ~ 410ms
+		if err != nil {
+			return nil, err
+		}
+
+		// Instantiations and tests are done on the first pass, even when there are no errors.
+		if len(errs) == 0 && !firstPass {
+			break
+		}
+
+		// Look in the files directly associated with the package for
+		// comment pragmas jig:file and jig:type.
+		report(p.LoadGeneratePragmas())
+
+		if tplr == nil {
+			if tplr, err = load(); err != nil {
+				return nil, err
+			}
+		}
+
+		if firstPass {
+			// Specialize templates requested by jig:instantiate pragmas and on the command-line.
+			messages, err := p.InstantiateGenerics(tplr, instantiate)
+			report(messages)
+			if err != nil {
+				return nil, err
+			}
+			generating = len(messages) > 0
+
+			// Add the tests missing for code generated before.
+			messages, err = p.GenerateMissingTests(tplr)
+			report(messages)
+			if err != nil {
+				return nil, err
+			}
+			generating = generating || len(messages) > 0
+			firstPass = false
+		}
+
+		// Implement missing language constructs.
+		for _, sig := range p.SuggestTypesToGenerate(errs) {
+			messages, err := tplr.GenerateCodeForType(p, sig)
+			report(messages)
+			if err != nil {
+				return nil, err
+			}
+			generating = generating || len(messages) > 0
+		}
+
+		// Make the package refer to code generated into the output package.
+		messages, err := p.RewriteReferences(errs)
+		report(messages)
+		if err != nil {
+			return nil, err
+		}
+		generating = generating || len(messages) > 0
+	}
+	return errs, nil
+}
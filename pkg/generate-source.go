@@ -5,14 +5,24 @@ import (
 	"bytes"
 	"fmt"
 	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io"
 	"math"
+	gopath "path"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"text/template"
+	"unicode"
 
-	"golang.org/x/tools/imports"
+	"golang.org/x/tools/go/ast/astutil"
+	goimports "golang.org/x/tools/imports"
+
+	"github.com/reactivego/jig/templ"
 )
 
 // ScanForGeneratedSources looks in the comments of the file for comment pragma //jig:name and
@@ -26,6 +36,7 @@ func (p *Package) ScanForGeneratedSources(file *ast.File) {
 				if len(kvmatch) == 3 {
 					if kvmatch[1] == jigName {
 						p.generated[kvmatch[2]] = p.Filepath(file)
+						p.disk[p.Filepath(file)] = struct{}{}
 						p.AddFile(file)
 					}
 				}
@@ -41,46 +52,91 @@ func (p *Package) HasGeneratedSource(name string) bool {
 	return present
 }
 
-// GenerateSource will take the passed name and source and add it to the package.
+// WantsTests is used in the templ.PackageWriter interface to generate the tests of
+// specialized templates, also for fragments that were generated before without them.
+func (p *Package) WantsTests() bool {
+	return p.WithTests
+}
+
+// GenerateMissingTests specializes the tests of the fragments that were generated before
+// without them, when the package wants tests. The signature of a fragment is derived from
+// its name e.g. "StringStack Push" for "StringStack_Push".
+func (p *Package) GenerateMissingTests(tplr templ.Specializer) (messages []string, err error) {
+	if !p.WithTests {
+		return nil, nil
+	}
+	var names []string
+	for name := range p.generated {
+		if !strings.HasSuffix(name, "_test") && !p.HasGeneratedSource(name+"_test") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		msgs, err := tplr.GenerateCodeForType(p, strings.Replace(name, "_", " ", -1))
+		messages = append(messages, msgs...)
+		if err != nil {
+			return messages, err
+		}
+	}
+	return messages, nil
+}
+
+// GenerateSource will take the passed fragment and add it to the package.
 // You want multiple generated fragments to share a physical file on disk.
-func (p *Package) GenerateSource(packageName, name, source string) error {
-	return p.GenerateSourceAppendFile(p.filename, packageName, name, source)
+// Fragments containing tests are ignored, unless the package wants tests.
+func (p *Package) GenerateSource(fragment templ.Fragment) error {
+	if fragment.Test && !p.WithTests {
+		return nil
+	}
+	return p.GenerateSourceAppendFile(p.filename, fragment)
 }
 
 // GenerateSourceAppendFile will generate the source and append it to a
-// shared source file. Duh!
-func (p *Package) GenerateSourceAppendFile(filename *template.Template, packageName, name, source string) error {
+// shared source file. Duh! The imports the source was written against are
+// added to the file exactly as specified. Any imports still missing after
+// that are guessed by goimports.
+func (p *Package) GenerateSourceAppendFile(filename *template.Template, fragment templ.Fragment) error {
+	name, source := fragment.Name, fragment.Source
 	sourcebuf := &bytes.Buffer{}
-	data := map[string]string{
-		"Package": strings.Title(packageName),
-		"package": strings.ToLower(packageName),
-		"Name":    strings.Title(name),
-		"name":    strings.ToLower(name),
+	path, err := p.fragmentFilepath(filename, fragment)
+	if err != nil {
+		return err
+	}
+	if fragment.Test {
+		// e.g. stack.go -> stack_test.go
+		path = strings.TrimSuffix(path, ".go") + "_test.go"
 	}
-	filenamebuf := &bytes.Buffer{}
-	filename.Execute(filenamebuf, data)
-	path := filepath.Join(p.Dir, filenamebuf.String())
 	if file, present := p.fileset[path]; present {
 		err := p.WriteFile(sourcebuf, file)
 		if err != nil {
 			return err
 		}
 	} else {
-		fmt.Fprintf(sourcebuf, "// Code generated by jig; DO NOT EDIT.\n\n//go:generate jig\n\npackage %v\n\n", p.Name)
+		if err := p.writeHeader(sourcebuf, !fragment.Test); err != nil {
+			return err
+		}
 	}
 
 	// Append the source fragment to the source.
-	fmt.Fprintf(sourcebuf, "\n%s %s\n\n%v", jigName, name, source)
+	offset := sourcebuf.Len()
+	fmt.Fprintf(sourcebuf, "\n%s %s\n\n%v", jigName, name, relativeLineDirectives(source, filepath.Dir(path)))
+
+	// Add the imports used by the source fragment.
+	err = p.addImports(sourcebuf, offset, fragment.Imports)
+	if err != nil {
+		return err
+	}
 
 	// Rewrite imports clause for the source.
-	fixedsource, err := imports.Process("", sourcebuf.Bytes(), nil)
+	fixedsource, err := goimports.Process("", sourcebuf.Bytes(), nil)
 	if err != nil {
-		return newSourceError(sourcebuf, err)
+		return fragmentError(fragment, newSourceError(sourcebuf, err))
 	}
 
 	file, err := p.Config.ParseFile(path, string(fixedsource))
 	if err != nil {
-		return newSourceError(bytes.NewBuffer(fixedsource), err)
+		return fragmentError(fragment, newSourceError(bytes.NewBuffer(fixedsource), err))
 	}
 
 	// Add file to the fileset, idempotent
@@ -88,9 +144,267 @@ func (p *Package) GenerateSourceAppendFile(filename *template.Template, packageN
 
 	// Remember this file as the most current one.
 	p.generated[name] = path
+	p.emit(Event{Event: FragmentGenerated, Package: fragment.PackagePath, Template: fragment.Generic, Test: fragment.Test, Fragment: name, Types: fragment.Types, File: path})
 	return nil
 }
 
+// writeHeader writes the header of a new file with generated source. The header
+// starts with the banner given by jig:header or jig:header-file pragmas, then
+// marks the file as generated and contains the go:generate directive that will
+// run jig again with the flags it was invoked with. The directive is left out
+// when generate is false, so go generate does not run jig for a _test.go file.
+func (p *Package) writeHeader(output io.Writer, generate bool) error {
+	command := p.Command
+	if p.OutputDir != "" {
+		// The go:generate directive is run from the output dir.
+		rel, err := relativeDir(p.OutputDir, p.Dir)
+		if err != nil {
+			return err
+		}
+		command = fmt.Sprintf("%s -o . --pkg %s %s", command, p.OutputName, rel)
+	}
+	if p.header != nil {
+		data := map[string]string{
+			"Package": p.outputName(),
+			"Command": command,
+		}
+		var banner bytes.Buffer
+		if err := p.header.Execute(&banner, data); err != nil {
+			return err
+		}
+		text := strings.TrimSpace(banner.String())
+		if strings.HasPrefix(text, "/*") && strings.HasSuffix(text, "*/") {
+			// A banner that is a block comment is written as is.
+			fmt.Fprintln(output, text)
+		} else {
+			for _, line := range strings.Split(text, "\n") {
+				if line = strings.TrimRight(line, " \t\r"); !strings.HasPrefix(line, "//") {
+					line = strings.TrimRight("// "+line, " ")
+				}
+				fmt.Fprintln(output, line)
+			}
+		}
+		fmt.Fprintln(output)
+	}
+	if !generate {
+		fmt.Fprintf(output, "// Code generated by jig; DO NOT EDIT.\n\npackage %v\n\n", p.outputName())
+		return nil
+	}
+	fmt.Fprintf(output, "// Code generated by jig; DO NOT EDIT.\n\n//go:generate %s\n\npackage %v\n\n", command, p.outputName())
+	return nil
+}
+
+// relativeDir returns the path of dir relative to base, with forward slashes. Both
+// are made absolute first, as a path relative to the current dir e.g. "../stackgen"
+// cannot be related to "." otherwise.
+func relativeDir(base, dir string) (string, error) {
+	base, err := filepath.Abs(base)
+	if err != nil {
+		return "", err
+	}
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(base, dir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// fragmentFilepath returns the path of the file the fragment should be appended to.
+// The filename template is executed with data describing the fragment. When the
+// number of lines in a file is capped, the fragment goes into the first file of
+// the series e.g. rx.go, rx_2.go, rx_3.go... that still has room for it.
+func (p *Package) fragmentFilepath(filename *template.Template, fragment templ.Fragment) (string, error) {
+	data := map[string]interface{}{
+		"Package":  strings.Title(fragment.PackageName),
+		"package":  strings.ToLower(fragment.PackageName),
+		"Name":     strings.Title(fragment.Name),
+		"name":     strings.ToLower(fragment.Name),
+		"Template": strings.Title(fragment.Template),
+		"template": strings.ToLower(fragment.Template),
+		"Type":     strings.Title(fragment.Type),
+		"type":     strings.ToLower(fragment.Type),
+		"Types":    fragment.Types,
+		"Vars":     fragment.Vars,
+		"Path":     fragment.PackagePath,
+	}
+	filenamebuf := &bytes.Buffer{}
+	if err := filename.Execute(filenamebuf, data); err != nil {
+		return "", err
+	}
+	path := filepath.Join(p.outputDir(), filenamebuf.String())
+	if p.maxLines <= 0 {
+		return path, nil
+	}
+	lines := strings.Count(fragment.Source, "\n") + 2
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for n := 2; ; n++ {
+		file, present := p.fileset[path]
+		if !present || p.Fset.File(file.Pos()).LineCount()+lines <= p.maxLines {
+			return path, nil
+		}
+		path = fmt.Sprintf("%s_%d%s", base, n, ext)
+	}
+}
+
+// relativeLineDirectives makes the paths in the //line directives of the source relative to
+// dir, the dir of the file the source is generated into. The compiler and the go/scanner
+// package resolve a relative path against the dir of the file containing the directive,
+// so the directives lead to the template library without depending on where the package
+// is found on disk.
+// e.g. "//line /home/user/go/src/github.com/reactivego/rx/generic/observable.go:123" with
+// dir "/home/user/go/src/github.com/reactivego/rx/test" -> "//line ../generic/observable.go:123"
+func relativeLineDirectives(source, dir string) string {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return source
+	}
+	return reLineDirective.ReplaceAllStringFunc(source, func(directive string) string {
+		match := reLineDirective.FindStringSubmatch(directive)
+		if !filepath.IsAbs(match[1]) {
+			return directive
+		}
+		rel, err := filepath.Rel(abs, match[1])
+		if err != nil {
+			return directive
+		}
+		return fmt.Sprintf("//line %s:%s", filepath.ToSlash(rel), match[2])
+	})
+}
+
+// reLineDirective matches a //line directive for a position in a file.
+// Matches will contain the path and the line e.g. ["//line /src/rx/observable.go:12", "/src/rx/observable.go", "12"]
+var reLineDirective = regexp.MustCompile(`(?m)^//line (.+):([0-9]+)$`)
+
+// addImports will add the given imports to the source in the buffer, the source of the
+// fragment starts at offset. When an import conflicts with an import already present,
+// e.g. "crypto/rand" with "math/rand", the import is given an alias and the source of the
+// fragment is made to refer to the package by that alias.
+func (p *Package) addImports(sourcebuf *bytes.Buffer, offset int, imports []templ.Import) error {
+	if len(imports) == 0 {
+		return nil
+	}
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", sourcebuf.Bytes(), parser.ParseComments)
+	if err != nil {
+		return newSourceError(sourcebuf, err)
+	}
+	fragmentPos := fset.File(file.Pos()).Pos(offset)
+	for _, imp := range imports {
+		if imp.Name == "." || imp.Name == "_" {
+			astutil.AddNamedImport(fset, file, imp.Name, imp.Path)
+			continue
+		}
+		local := p.importName(imp.Name, imp.Path)
+		name, present := "", false
+		taken := make(map[string]bool)
+		for _, spec := range file.Imports {
+			path, _ := strconv.Unquote(spec.Path.Value)
+			specName := ""
+			if spec.Name != nil {
+				specName = spec.Name.Name
+			}
+			if specName == "." || specName == "_" {
+				continue
+			}
+			if path == imp.Path {
+				name, present = p.importName(specName, path), true
+			}
+			taken[p.importName(specName, path)] = true
+		}
+		if !present {
+			name = local
+			for n := 1; taken[name]; n++ {
+				name = importAlias(imp.Path, local)
+				if n > 1 {
+					name += strconv.Itoa(n)
+				}
+			}
+			specName := imp.Name
+			if name != local {
+				specName = name
+			}
+			astutil.AddNamedImport(fset, file, specName, imp.Path)
+		}
+		if name != local {
+			renameQualifier(file, fragmentPos, local, name)
+		}
+	}
+	sourcebuf.Reset()
+	return format.Node(sourcebuf, fset, file)
+}
+
+// importName returns the name by which a file refers to the package imported with the
+// given path. Unless the import is renamed, this is the name of the package itself.
+func (p *Package) importName(name, path string) string {
+	if name != "" {
+		return name
+	}
+	for _, pkgInfo := range p.allPackages {
+		if pkgInfo.Pkg.Path() == path {
+			return pkgInfo.Pkg.Name()
+		}
+	}
+	return assumedName(path)
+}
+
+// assumedName guesses the name of a package that has not been loaded from its import
+// path, the same way goimports does e.g. "yaml" for "gopkg.in/yaml.v2" and "foo" for
+// "github.com/x/go-foo/v2".
+func assumedName(path string) string {
+	base := gopath.Base(path)
+	if strings.HasPrefix(base, "v") {
+		if _, err := strconv.Atoi(base[1:]); err == nil && gopath.Dir(path) != "." {
+			base = gopath.Base(gopath.Dir(path))
+		}
+	}
+	base = strings.TrimPrefix(base, "go-")
+	if i := strings.IndexFunc(base, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	}); i >= 0 {
+		base = base[:i]
+	}
+	return base
+}
+
+// importAlias returns the alias for an import whose name conflicts with that of another
+// import, the name prefixed with the parent dir in its path e.g. "cryptorand".
+func importAlias(path, name string) string {
+	alias := name
+	if dir := gopath.Dir(path); dir != "." {
+		alias = assumedName(dir) + name
+	}
+	return strings.ToLower(alias)
+}
+
+// renameQualifier makes the declarations following pos refer to an imported package by
+// another name. Identifiers that are declared in the file are left alone.
+func renameQualifier(file *ast.File, pos token.Pos, from, to string) {
+	for _, decl := range file.Decls {
+		if decl.Pos() < pos {
+			continue
+		}
+		ast.Inspect(decl, func(node ast.Node) bool {
+			if sel, ok := node.(*ast.SelectorExpr); ok {
+				if x, ok := sel.X.(*ast.Ident); ok && x.Name == from && x.Obj == nil {
+					x.Name = to
+				}
+			}
+			return true
+		})
+	}
+}
+
+// fragmentError returns the error in the source of the fragment as a TemplateError for
+// the template it was specialized from, as the template is what needs fixing.
+func fragmentError(fragment templ.Fragment, err error) error {
+	return &templ.TemplateError{Template: fragment.Generic, PackagePath: fragment.PackagePath, Err: err}
+}
+
 func newSourceError(sourcebuf *bytes.Buffer, err error) error {
 
 	reErr := regexp.MustCompile("^[^0-9]*([0-9]+):(.*)$")
@@ -0,0 +1,96 @@
+package pkg
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/reactivego/jig/templ"
+)
+
+// generatedSource generates the fragments into a new package dir and returns the
+// source of the file they were generated into.
+func generatedSource(t *testing.T, fragments ...templ.Fragment) string {
+	t.Helper()
+	dir := writeModule(t, map[string]string{"use.go": "package lib\n"})
+	p := modulePackage(t, dir, nil)
+	for _, fragment := range fragments {
+		fragment.PackageName = "lib"
+		if err := p.GenerateSource(fragment); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return fileSource(t, p, filepath.Join(dir, "lib.go"))
+}
+
+func TestGenerateSourceImports(t *testing.T) {
+	intn := templ.Fragment{
+		Name:    "Intn",
+		Source:  "func Intn() int { return rand.Intn(2) }\n",
+		Imports: []templ.Import{{Path: "math/rand"}},
+	}
+	tests := []struct {
+		name      string
+		fragments []templ.Fragment
+		contains  []string
+	}{{
+		name: "renamed import is kept",
+		fragments: []templ.Fragment{{
+			Name:    "Read",
+			Source:  "func Read(b []byte) { crand.Read(b) }\n",
+			Imports: []templ.Import{{Name: "crand", Path: "crypto/rand"}},
+		}},
+		contains: []string{`import crand "crypto/rand"`, "crand.Read(b)"},
+	}, {
+		name: "conflicting import is aliased",
+		fragments: []templ.Fragment{intn, {
+			Name:    "Read",
+			Source:  "func Read(b []byte) { rand.Read(b) }\n",
+			Imports: []templ.Import{{Path: "crypto/rand"}},
+		}},
+		contains: []string{`cryptorand "crypto/rand"`, `"math/rand"`, "rand.Intn(2)", "cryptorand.Read(b)"},
+	}, {
+		name: "conflicting renamed import is aliased",
+		fragments: []templ.Fragment{intn, {
+			Name:    "Read",
+			Source:  "func Read(b []byte) { rand.Read(b) }\n",
+			Imports: []templ.Import{{Name: "rand", Path: "crypto/rand"}},
+		}},
+		contains: []string{`cryptorand "crypto/rand"`, "rand.Intn(2)", "cryptorand.Read(b)"},
+	}, {
+		name: "import shared by fragments is added once",
+		fragments: []templ.Fragment{intn, {
+			Name:    "Perm",
+			Source:  "func Perm() []int { return rand.Perm(2) }\n",
+			Imports: []templ.Import{{Path: "math/rand"}},
+		}},
+		contains: []string{`import "math/rand"`, "rand.Intn(2)", "rand.Perm(2)"},
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source := generatedSource(t, test.fragments...)
+			for _, s := range test.contains {
+				if !strings.Contains(source, s) {
+					t.Errorf("source does not contain %q\n%s", s, source)
+				}
+			}
+		})
+	}
+}
+
+func TestLineDirectives(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"lib/stack.go": stackLibrary("lib", ""),
+		"app/app.go":   "package app\n\nimport _ \"example.com/test/lib\"\n\nfunc use() {\n\tvar s StringStack\n\ts.Push(\"x\")\n}\n",
+	})
+	p := modulePackage(t, filepath.Join(dir, "app"), func(p *Package) {
+		p.LineDirectives = true
+	})
+	generateCode(t, p)
+	source := fileSource(t, p, filepath.Join(dir, "app", "lib.go"))
+	for _, directive := range []string{"//line ../lib/stack.go:8\ntype StringStack", "//line ../lib/stack.go:12\nfunc (s *StringStack) Push"} {
+		if !strings.Contains(source, directive) {
+			t.Errorf("source does not contain %q\n%s", directive, source)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package pkg
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/reactivego/jig/templ"
+)
+
+// instantiation is a request to specialize a template for a list of types.
+type instantiation struct {
+	// alias is the name to give the specialized type or function, may be empty.
+	// e.g. "MyInts"
+	alias string
+	// template is the name of the template to specialize.
+	// e.g. "<Foo>Stack"
+	template string
+	// types contains the types to bind to the template vars.
+	// e.g. ["Int"]
+	types []string
+}
+
+// parseInstantiations parses the value of a jig:instantiate pragma. The value
+// either names a template followed by a comma separated list of types, or names
+// a template followed by the types between square brackets, optionally preceded
+// by a name for the result. Without brackets, the name of the template ends with
+// the last word containing a template var, unless the types then outnumber the
+// template vars. The extra words are part of the name of a method template.
+// e.g. "<Foo>Stack Int, String", "Observable<Foo> Map<Bar> Int String",
+// "<Foo>Stack Push Int" or "MyInts = <Foo>Stack[Int]"
+func parseInstantiations(spec string) ([]instantiation, error) {
+	spec = strings.TrimSpace(spec)
+	if match := reInstantiateBrackets.FindStringSubmatch(spec); match != nil {
+		var types []string
+		for _, typ := range strings.Split(match[3], ",") {
+			types = append(types, strings.TrimSpace(typ))
+		}
+		template := strings.TrimSpace(match[2])
+		if match[1] != "" && len(strings.Fields(template)) != 1 {
+			return nil, fmt.Errorf("cannot name the instantiation of method template %q", template)
+		}
+		return []instantiation{{alias: match[1], template: template, types: types}}, nil
+	}
+	fields := strings.Fields(spec)
+	if len(fields) < 2 || strings.ContainsAny(spec, "[]=") {
+		return nil, fmt.Errorf("expected \"<template> <types>[, <types>]\" or \"[<name> =] <template>[<types>]\", got %q", spec)
+	}
+	// The name of the template ends with the last word containing a template var.
+	end := 0
+	vars := make(map[string]struct{})
+	for i, field := range fields {
+		for _, match := range reTemplateVar.FindAllStringSubmatch(field, -1) {
+			vars[match[1]] = struct{}{}
+			end = i + 1
+		}
+	}
+	if len(vars) == 0 {
+		return nil, fmt.Errorf("template %q has no template vars", fields[0])
+	}
+	// Words in front of the types that outnumber the template vars name a method.
+	if first := strings.Fields(strings.Split(strings.Join(fields[end:], " "), ",")[0]); len(first) > len(vars) {
+		end += len(first) - len(vars)
+	}
+	template := strings.Join(fields[:end], " ")
+	var instantiations []instantiation
+	for _, group := range strings.Split(strings.Join(fields[end:], " "), ",") {
+		types := strings.Fields(group)
+		if len(types) == 0 {
+			return nil, fmt.Errorf("missing types in %q", spec)
+		}
+		if len(types) != len(vars) {
+			return nil, fmt.Errorf("template %q needs %d types, got %q", template, len(vars), strings.TrimSpace(group))
+		}
+		instantiations = append(instantiations, instantiation{template: template, types: types})
+	}
+	return instantiations, nil
+}
+
+// ValidateInstantiation returns an error when the spec does not use the syntax of the
+// jig:instantiate pragma, e.g. for specs given on the command-line.
+func ValidateInstantiation(spec string) error {
+	_, err := parseInstantiations(spec)
+	return err
+}
+
+// signature returns the signature of the specialized template.
+// e.g. "<Foo>Stack Push" with types ["Int"] -> "IntStack Push"
+func (i instantiation) signature() string {
+	n := 0
+	return reTemplateVar.ReplaceAllStringFunc(i.template, func(tplvar string) string {
+		if n == len(i.types) {
+			return tplvar
+		}
+		n++
+		return i.types[n-1]
+	})
+}
+
+// HasInstantiatePragmas returns true when a file of the package contains a jig:instantiate
+// pragma. Unlike LoadGeneratePragmas, it only looks at the comments of the files.
+func (p *Package) HasInstantiatePragmas() bool {
+	for _, pkgInfo := range p.PkgSpec() {
+		for _, file := range pkgInfo.Files {
+			for _, cgroup := range file.Comments {
+				for _, comment := range cgroup.List {
+					if kvmatch := reCommentPragma.FindStringSubmatch(comment.Text); len(kvmatch) == 3 && kvmatch[1] == jigInstantiate {
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
+
+// InstantiateGenerics specializes the templates named by the jig:instantiate pragmas
+// of the package and by the given specs, which use the syntax of the pragma. The
+// generated fragments are pinned so they are never pruned.
+func (p *Package) InstantiateGenerics(tplr templ.Specializer, specs []string) (messages []string, err error) {
+	var instantiations []instantiation
+	for _, spec := range p.instantiate {
+		insts, _ := parseInstantiations(spec)
+		instantiations = append(instantiations, insts...)
+	}
+	for _, spec := range specs {
+		insts, err := parseInstantiations(spec)
+		if err != nil {
+			return messages, err
+		}
+		instantiations = append(instantiations, insts...)
+	}
+	for _, inst := range instantiations {
+		msgs, err := tplr.Instantiate(p, inst.alias, inst.template, inst.types)
+		messages = append(messages, msgs...)
+		if err != nil {
+			return messages, err
+		}
+		name := strings.Replace(inst.signature(), " ", "_", -1)
+		p.addKeep(name)
+		if len(strings.Fields(inst.template)) == 1 {
+			p.addKeep(name + "_*")
+		}
+		if inst.alias != "" {
+			p.addKeep(inst.alias)
+		}
+	}
+	return messages, nil
+}
+
+// reInstantiateBrackets matches the value of a jig:instantiate pragma with the types between square brackets.
+// Matches will contain the name, template and types e.g. ["MyInts = <Foo>Stack[Int]", "MyInts", "<Foo>Stack", "Int"]
+var reInstantiateBrackets = regexp.MustCompile(`^(?:([[:word:]]+)[[:space:]]*=[[:space:]]*)?([^=\[\]]+)\[([^\[\]]+)\]$`)
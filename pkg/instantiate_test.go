@@ -0,0 +1,111 @@
+package pkg
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseInstantiations(t *testing.T) {
+	tests := []struct {
+		spec           string
+		instantiations []instantiation
+		err            bool
+	}{{
+		spec:           "<Foo>Stack Int, String",
+		instantiations: []instantiation{{template: "<Foo>Stack", types: []string{"Int"}}, {template: "<Foo>Stack", types: []string{"String"}}},
+	}, {
+		spec:           "Observable<Foo> Map<Bar> Int String",
+		instantiations: []instantiation{{template: "Observable<Foo> Map<Bar>", types: []string{"Int", "String"}}},
+	}, {
+		spec:           "Observable<Foo> Map<Bar> Int String, Bool Int",
+		instantiations: []instantiation{{template: "Observable<Foo> Map<Bar>", types: []string{"Int", "String"}}, {template: "Observable<Foo> Map<Bar>", types: []string{"Bool", "Int"}}},
+	}, {
+		spec:           "<Foo>Stack Push Int",
+		instantiations: []instantiation{{template: "<Foo>Stack Push", types: []string{"Int"}}},
+	}, {
+		spec:           "<Foo>Stack Push[Int]",
+		instantiations: []instantiation{{template: "<Foo>Stack Push", types: []string{"Int"}}},
+	}, {
+		spec:           "Floats = <Foo>Stack[Float64]",
+		instantiations: []instantiation{{alias: "Floats", template: "<Foo>Stack", types: []string{"Float64"}}},
+	}, {
+		spec: "Observable<Foo> Map<Bar> Int",
+		err:  true,
+	}, {
+		spec: "<Foo>Stack Int,",
+		err:  true,
+	}, {
+		spec: "Stack Int",
+		err:  true,
+	}, {
+		spec: "<Foo>Stack",
+		err:  true,
+	}, {
+		spec: "Floats = <Foo>Stack Push[Float64]",
+		err:  true,
+	}}
+	for _, test := range tests {
+		t.Run(test.spec, func(t *testing.T) {
+			instantiations, err := parseInstantiations(test.spec)
+			if test.err {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", instantiations)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(instantiations, test.instantiations) {
+				t.Errorf("got %+v; expected %+v", instantiations, test.instantiations)
+			}
+		})
+	}
+}
+
+func TestInstantiateGenerics(t *testing.T) {
+	// pairLibrary adds a template with two template vars to the stack library.
+	pairLibrary := stackLibrary("lib", "type bar interface{}\n") + `
+//jig:template <Foo>Pair<Bar>
+
+type FooPairBar struct {
+	First  foo
+	Second bar
+}
+`
+	tests := []struct {
+		name      string
+		pragma    string
+		fragments []string
+	}{{
+		name:      "types",
+		pragma:    "<Foo>Stack Int, String",
+		fragments: []string{"IntStack", "IntStack_Push", "StringStack", "StringStack_Push"},
+	}, {
+		name:      "several template vars",
+		pragma:    "<Foo>Pair<Bar> Int String",
+		fragments: []string{"IntPairString"},
+	}, {
+		name:      "method",
+		pragma:    "<Foo>Stack Push Int",
+		fragments: []string{"IntStack", "IntStack_Push"},
+	}, {
+		name:      "alias",
+		pragma:    "Ints = <Foo>Stack[Int]",
+		fragments: []string{"IntStack", "IntStack_Push", "Ints"},
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := writeModule(t, map[string]string{
+				"lib/lib.go": pairLibrary,
+				"app/app.go": "package app\n\nimport _ \"example.com/test/lib\"\n\n//jig:instantiate " + test.pragma + "\n",
+			})
+			p := modulePackage(t, filepath.Join(dir, "app"), nil)
+			generateCode(t, p)
+			if fragments := generatedNames(p); !reflect.DeepEqual(fragments, test.fragments) {
+				t.Errorf("generated %q; expected %q", fragments, test.fragments)
+			}
+		})
+	}
+}
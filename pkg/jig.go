@@ -1,8 +1,10 @@
 package pkg
 
 import (
+	"fmt"
 	"go/ast"
 	"go/token"
+	"path/filepath"
 	"strings"
 
 	"github.com/reactivego/jig/templ"
@@ -14,9 +16,18 @@ type jig struct {
 	Pos    token.Pos
 	End    token.Pos
 	Source string
+}
+
+// fileImport is an import spec of a file containing jigs.
+type fileImport struct {
+	templ.Import
+
+	// local is the name by which the file refers to the imported package.
+	// e.g. "crand" for import crand "crypto/rand" and "rand" for import "crypto/rand"
+	local string
 
-	// common template is added to Needs [] of every other jig.
-	common bool
+	// scope contains the exported names of a dot imported package.
+	scope []string
 }
 
 func newJig(packageName string, cgroup *ast.CommentGroup) *jig {
@@ -25,7 +36,7 @@ func newJig(packageName string, cgroup *ast.CommentGroup) *jig {
 	jig.Pos = cgroup.End()
 	for _, comment := range cgroup.List {
 		if comment.Text == jigCommon {
-			jig.common = true
+			jig.Common = true
 			continue
 		}
 
@@ -34,6 +45,9 @@ func newJig(packageName string, cgroup *ast.CommentGroup) *jig {
 			switch kvmatch[1] {
 			case jigTemplate:
 				jig.Name = kvmatch[2]
+			case jigTest:
+				jig.Name = kvmatch[2]
+				jig.Test = true
 			case jigNeeds:
 				needs := strings.Split(kvmatch[2], ",")
 				for _, need := range needs {
@@ -79,6 +93,68 @@ func (jig *jig) ContainsSourceRange(pos, end token.Pos) bool {
 	return pos > jig.Pos && end < jig.End
 }
 
+// AddImports will add the imports of the file that are used by decl to the
+// imports of the jig.
+func (jig *jig) AddImports(decl ast.Node, imports []fileImport) {
+	if len(imports) == 0 {
+		return
+	}
+	qualifiers := make(map[string]bool)
+	idents := make(map[string]bool)
+	ast.Inspect(decl, func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.SelectorExpr:
+			if x, ok := n.X.(*ast.Ident); ok {
+				qualifiers[x.Name] = true
+			}
+		case *ast.Ident:
+			idents[n.Name] = true
+		}
+		return true
+	})
+	for _, imp := range imports {
+		used := qualifiers[imp.local]
+		if imp.Name == "." {
+			for _, name := range imp.scope {
+				if idents[name] {
+					used = true
+					break
+				}
+			}
+		}
+		if used && !jig.hasImport(imp.Import) {
+			jig.Imports = append(jig.Imports, imp.Import)
+		}
+	}
+}
+
+func (jig *jig) hasImport(imp templ.Import) bool {
+	for _, i := range jig.Imports {
+		if i == imp {
+			return true
+		}
+	}
+	return false
+}
+
+// AddLine adds the position of a declaration to the jig and returns the
+// line marker by which the source of the jig refers to it. The position
+// contains the absolute path of the file in the template library.
+func (jig *jig) AddLine(position token.Position) string {
+	filename := position.Filename
+	if abs, err := filepath.Abs(filename); err == nil {
+		filename = abs
+	}
+	jig.Lines = append(jig.Lines, fmt.Sprintf("%s:%d", filename, position.Line))
+	return fmt.Sprintf("//line #%d\n", len(jig.Lines)-1)
+}
+
+// lineEndMarker marks the end of a declaration that a line marker refers to. When
+// the file with the generated source is written, it is replaced by a //line directive
+// pointing back into that file, so the code that follows is not attributed to the
+// template.
+const lineEndMarker = "//line #end"
+
 func (jig *jig) AddSource(source string) {
 	if jig.Source != "" {
 		jig.Source += "\n"
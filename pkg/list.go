@@ -0,0 +1,35 @@
+package pkg
+
+import (
+	"sort"
+
+	"github.com/reactivego/jig/templ"
+)
+
+// ListGenerics returns the generics found in the package and the packages it imports, sorted
+// by import path and name. The package must have been checked before generics can be listed.
+func (p *Package) ListGenerics() ([]templ.Generic, []string, error) {
+	collector := &genericsCollector{}
+	messages, err := p.LoadGenerics(collector)
+	if err != nil {
+		return nil, messages, err
+	}
+	generics := collector.generics
+	sort.SliceStable(generics, func(i, j int) bool {
+		if generics[i].PackagePath != generics[j].PackagePath {
+			return generics[i].PackagePath < generics[j].PackagePath
+		}
+		return generics[i].Name < generics[j].Name
+	})
+	return generics, messages, nil
+}
+
+// GeneratedSources returns the names of the generated source fragments of the package,
+// mapped to the paths of the files that contain them.
+func (p *Package) GeneratedSources() map[string]string {
+	generated := make(map[string]string)
+	for name, path := range p.generated {
+		generated[name] = path
+	}
+	return generated
+}
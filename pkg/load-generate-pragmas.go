@@ -3,21 +3,48 @@ package pkg
 import (
 	"fmt"
 	"go/ast"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"text/template"
 )
 
+// LoadGeneratePragmas loads the generator pragmas found in the files of the package. The
+// jig.yaml file nearest to the package dir provides the defaults, so pragmas override the
+// settings in that file. The libraries preferred by jig:prefer pragmas come before those
+// preferred by the file, type mappings of jig:type pragmas replace those of the file and
+// the fragments to keep are combined. The banner is given by the header pragmas of a single
+// file, header pragmas in other files are ignored.
 func (p *Package) LoadGeneratePragmas() (messages []string) {
+	config, err := p.loadProjectConfig()
+	if err == nil && config != nil {
+		err = config.applyDefaults(p)
+	}
+	if err != nil {
+		messages = append(messages, fmt.Sprintf("ignoring %s", configName), err.Error())
+		config = nil
+	} else if config != nil {
+		messages = append(messages, fmt.Sprintf("using %q", config.path))
+	}
+	var header headerPragmas
 	for _, pkgInfo := range p.PkgSpec() {
 		for _, file := range pkgInfo.Files {
-			msgs := p.loadGeneratePragmasFromFile(file)
+			msgs := p.loadGeneratePragmasFromFile(file, &header)
 			messages = append(messages, msgs...)
 		}
 	}
+	messages = append(messages, p.loadHeader(&header)...)
+	if config != nil {
+		for _, path := range config.prefer {
+			p.addPrefer(path)
+		}
+	}
 	return messages
 }
 
-func (p *Package) loadGeneratePragmasFromFile(file *ast.File) (messages []string) {
+func (p *Package) loadGeneratePragmasFromFile(file *ast.File, header *headerPragmas) (messages []string) {
+	path := p.Filepath(file)
 	for _, cgroup := range file.Comments {
 		for _, comment := range cgroup.List {
 			// jig:file <filename>
@@ -32,6 +59,51 @@ func (p *Package) loadGeneratePragmasFromFile(file *ast.File) (messages []string
 					}
 				}
 			}
+			// jig:layout <layout>
+			if strings.HasPrefix(comment.Text, jigLayout) {
+				kvmatch := reCommentPragma.FindStringSubmatch(comment.Text)
+				if len(kvmatch) == 3 && kvmatch[1] == jigLayout {
+					if layout, present := layouts[strings.TrimSpace(kvmatch[2])]; present {
+						p.filename = template.Must(template.New("filename").Parse(layout))
+					} else {
+						messages = append(messages, fmt.Sprintf("ignoring pragma //jig:layout %s", kvmatch[2]), "unknown layout")
+					}
+				}
+			}
+			// jig:max-lines <lines>
+			if strings.HasPrefix(comment.Text, jigMaxLines) {
+				kvmatch := reCommentPragma.FindStringSubmatch(comment.Text)
+				if len(kvmatch) == 3 && kvmatch[1] == jigMaxLines {
+					maxLines, err := strconv.Atoi(strings.TrimSpace(kvmatch[2]))
+					if err == nil {
+						p.maxLines = maxLines
+					} else {
+						messages = append(messages, fmt.Sprintf("ignoring pragma //jig:max-lines %s", kvmatch[2]), err.Error())
+					}
+				}
+			}
+			// jig:header <text>
+			if strings.HasPrefix(comment.Text, jigHeader) {
+				kvmatch := reCommentPragma.FindStringSubmatch(comment.Text)
+				if len(kvmatch) == 3 && kvmatch[1] == jigHeader {
+					if msgs := header.given(path, comment.Text); msgs == nil {
+						header.lines = append(header.lines, kvmatch[2])
+					} else {
+						messages = append(messages, msgs...)
+					}
+				}
+			}
+			// jig:header-file <path>
+			if strings.HasPrefix(comment.Text, jigHeaderFile) {
+				kvmatch := reCommentPragma.FindStringSubmatch(comment.Text)
+				if len(kvmatch) == 3 && kvmatch[1] == jigHeaderFile {
+					if msgs := header.given(path, comment.Text); msgs == nil {
+						header.path = strings.TrimSpace(kvmatch[2])
+					} else {
+						messages = append(messages, msgs...)
+					}
+				}
+			}
 			// jig:type <display type> <real type>
 			if strings.HasPrefix(comment.Text, jigType) {
 				kvmatch := reJigType.FindStringSubmatch(comment.Text)
@@ -39,6 +111,33 @@ func (p *Package) loadGeneratePragmasFromFile(file *ast.File) (messages []string
 					p.typemap[kvmatch[1]] = kvmatch[2]
 				}
 			}
+			// jig:prefer <importpath>
+			if strings.HasPrefix(comment.Text, jigPrefer) {
+				kvmatch := reCommentPragma.FindStringSubmatch(comment.Text)
+				if len(kvmatch) == 3 && kvmatch[1] == jigPrefer {
+					p.addPrefer(strings.TrimSpace(kvmatch[2]))
+				}
+			}
+			// jig:keep <name>[, <name>]
+			if strings.HasPrefix(comment.Text, jigKeep) {
+				kvmatch := reCommentPragma.FindStringSubmatch(comment.Text)
+				if len(kvmatch) == 3 && kvmatch[1] == jigKeep {
+					for _, name := range strings.Split(kvmatch[2], ",") {
+						p.addKeep(strings.TrimSpace(name))
+					}
+				}
+			}
+			// jig:instantiate <template> <types>[, <types>] or [<name> =] <template>[<types>]
+			if strings.HasPrefix(comment.Text, jigInstantiate) {
+				kvmatch := reCommentPragma.FindStringSubmatch(comment.Text)
+				if len(kvmatch) == 3 && kvmatch[1] == jigInstantiate {
+					if _, err := parseInstantiations(kvmatch[2]); err == nil {
+						p.addInstantiate(strings.TrimSpace(kvmatch[2]))
+					} else {
+						messages = append(messages, fmt.Sprintf("ignoring pragma //jig:instantiate %s", kvmatch[2]), err.Error())
+					}
+				}
+			}
 			// jig:force-common-code-generation
 			if strings.HasPrefix(comment.Text, jigForceCommon) {
 				p.forceCommon = true
@@ -51,3 +150,89 @@ func (p *Package) loadGeneratePragmasFromFile(file *ast.File) (messages []string
 	}
 	return messages
 }
+
+// headerPragmas contains the jig:header and jig:header-file pragmas of the package.
+type headerPragmas struct {
+	// file is the path of the file in which the banner is given.
+	file string
+	// lines contains the values of the jig:header pragmas.
+	lines []string
+	// path is the value of the jig:header-file pragma.
+	path string
+}
+
+// given returns the messages reporting the header pragma as ignored when the banner
+// was already given in another file, otherwise nil.
+func (h *headerPragmas) given(path, pragma string) []string {
+	if h.file != "" && h.file != path {
+		return []string{fmt.Sprintf("ignoring pragma %s", pragma), fmt.Sprintf("header already given in file %q", h.file)}
+	}
+	h.file = path
+	return nil
+}
+
+// loadHeader sets the banner given by the header pragmas. The lines of jig:header
+// pragmas take precedence over a jig:header-file pragma.
+func (p *Package) loadHeader(h *headerPragmas) (messages []string) {
+	var (
+		content string
+		err     error
+	)
+	switch {
+	case len(h.lines) > 0:
+		if h.path != "" {
+			messages = append(messages, fmt.Sprintf("ignoring pragma //jig:header-file %s", h.path), "header given by //jig:header pragmas")
+		}
+		content = strings.Join(h.lines, "\n")
+	case h.path != "":
+		var b []byte
+		b, err = ioutil.ReadFile(filepath.Join(p.Dir, h.path))
+		content = string(b)
+	default:
+		return nil
+	}
+	if err == nil {
+		var tpl *template.Template
+		if tpl, err = template.New("header").Parse(content); err == nil {
+			p.header = tpl
+			return messages
+		}
+	}
+	if len(h.lines) > 0 {
+		return append(messages, "ignoring pragma //jig:header", err.Error())
+	}
+	return append(messages, fmt.Sprintf("ignoring pragma //jig:header-file %s", h.path), err.Error())
+}
+
+// addPrefer adds the import path to the list of preferred template libraries,
+// unless it is already present.
+func (p *Package) addPrefer(path string) {
+	for _, prefer := range p.prefer {
+		if prefer == path {
+			return
+		}
+	}
+	p.prefer = append(p.prefer, path)
+}
+
+// addKeep adds the name pattern to the list of pinned fragments, unless it is
+// already present.
+func (p *Package) addKeep(pattern string) {
+	for _, keep := range p.keep {
+		if keep == pattern {
+			return
+		}
+	}
+	p.keep = append(p.keep, pattern)
+}
+
+// addInstantiate adds the value of a jig:instantiate pragma to the list of
+// instantiations, unless it is already present.
+func (p *Package) addInstantiate(spec string) {
+	for _, instantiate := range p.instantiate {
+		if instantiate == spec {
+			return
+		}
+	}
+	p.instantiate = append(p.instantiate, spec)
+}
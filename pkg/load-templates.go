@@ -4,8 +4,12 @@ import (
 	"bytes"
 	"fmt"
 	"go/ast"
+	"go/build"
+	"go/parser"
 	"go/printer"
 	"go/token"
+	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/reactivego/jig/templ"
@@ -20,26 +24,49 @@ func (p *Package) LoadGenerics(tplr templ.Specializer) (messages []string, err e
 		for _, file := range pkgInfo.Files {
 			jigs = append(jigs, p.LoadGenericsFromFile(file, ignoreSupportTemplates)...)
 		}
+		if pkgInfo != p.info && !p.isGoroot(pkgInfo.Pkg.Path()) && pkgInfo.Pkg.Path() != p.outputPath {
+			// Generic declarations of imported packages are templates too.
+			typeParamJigs, msgs := p.LoadTypeParamGenerics(pkgInfo)
+			jigs = append(jigs, typeParamJigs...)
+			messages = append(messages, msgs...)
+			// The loader leaves out test files, they contain the test templates.
+			testJigs, err := p.LoadTestGenerics(pkgInfo.Pkg.Path())
+			if err != nil {
+				return messages, &LoadError{Dir: p.Dir, Err: err}
+			}
+			jigs = append(jigs, testJigs...)
+		}
 		if jigs == nil {
 			continue
 		}
 		if !ignoreSupportTemplates {
 			p.transformCommonIntoNeeds(jigs)
 		}
+		tests := 0
+		for _, jig := range jigs {
+			if jig.Test {
+				tests++
+			}
+		}
 		// All jigs are read; for every Jig add Generic+Source to Specializer.
 		for _, jig := range jigs {
+			jig.PackagePath = pkgInfo.Pkg.Path()
 			err = tplr.Add(jig.Generic, jig.Source)
 			if err != nil {
 				return messages, err
 			}
+			p.emitPosition(Event{Event: TemplateFound, Package: jig.PackagePath, Template: jig.Name, Test: jig.Test}, p.Fset.Position(jig.Pos))
 		}
 		var msg string
 		if !ignoreSupportTemplates {
-			msg = fmt.Sprintf("found %d templates in package %q (%s)", len(jigs), pkgInfo.Pkg.Name(), pkgInfo.Pkg.Path())
+			msg = fmt.Sprintf("found %d templates in package %q (%s)", len(jigs)-tests, pkgInfo.Pkg.Name(), pkgInfo.Pkg.Path())
 		} else {
-			msg = fmt.Sprintf("found %d templates in package %q (%s) ignoring support templates", len(jigs), pkgInfo.Pkg.Name(), pkgInfo.Pkg.Path())
+			msg = fmt.Sprintf("found %d templates in package %q (%s) ignoring support templates", len(jigs)-tests, pkgInfo.Pkg.Name(), pkgInfo.Pkg.Path())
 		}
 		messages = append(messages, msg)
+		if tests > 0 {
+			messages = append(messages, fmt.Sprintf("found %d tests in package %q (%s)", tests, pkgInfo.Pkg.Name(), pkgInfo.Pkg.Path()))
+		}
 	}
 	tplr.Sort()
 	return messages, nil
@@ -59,12 +86,12 @@ func (p *Package) LoadGenericsFromFile(file *ast.File, ignoreSupportTemplates bo
 				jig.Close(cgroup.Pos())
 				jig = nil
 			}
-			// jig:template <name>
-			if strings.HasPrefix(comment.Text, jigTemplate) {
+			// jig:template <name> or jig:test <name>
+			if strings.HasPrefix(comment.Text, jigTemplate) || strings.HasPrefix(comment.Text, jigTest) {
 				jig.Close(cgroup.Pos())
 				packageName := file.Name.String()
 				jig = newJig(packageName, cgroup)
-				jigHasSupportingRole := jig.common || len(jig.Vars) == 0
+				jigHasSupportingRole := jig.Common || len(jig.Vars) == 0
 				if !ignoreSupportTemplates || !jigHasSupportingRole {
 					jigs = append(jigs, jig)
 				}
@@ -79,23 +106,93 @@ func (p *Package) LoadGenericsFromFile(file *ast.File, ignoreSupportTemplates bo
 	return jigs
 }
 
-// collectSources will visit all declarations in the file and collect the source for the jigs.
+// collectSources will visit all declarations in the file and collect the source and imports for the jigs.
+// With LineDirectives set, the source of every declaration is preceded by a line marker referring to the
+// original position of the declaration.
 func (p *Package) collectSources(jigs []*jig, file *ast.File) {
-	ast.Walk(sourceCollector{Fset: p.Fset, Snippets: jigs, Nodoc: p.Nodoc}, file)
+	ast.Walk(sourceCollector{Fset: p.Fset, Snippets: jigs, Nodoc: p.Nodoc, LineDirectives: p.LineDirectives, Imports: p.fileImports(file), Decls: file.Decls, Comments: file.Comments}, file)
+}
+
+// fileImports returns the import specs of the file. The name by which the file
+// refers to an imported package is taken from the loaded package when it is not
+// explicitly given in the import spec.
+func (p *Package) fileImports(file *ast.File) []fileImport {
+	var imports []fileImport
+	for _, spec := range file.Imports {
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		imp := fileImport{Import: templ.Import{Path: path}, local: assumedName(path)}
+		if spec.Name != nil {
+			imp.Name = spec.Name.Name
+			imp.local = spec.Name.Name
+		}
+		if imp.Name == "_" {
+			continue
+		}
+		for _, pkgInfo := range p.allPackages {
+			if pkgInfo.Pkg.Path() != path {
+				continue
+			}
+			if imp.Name == "" {
+				imp.local = pkgInfo.Pkg.Name()
+			}
+			if imp.Name == "." {
+				for _, name := range pkgInfo.Pkg.Scope().Names() {
+					if ast.IsExported(name) {
+						imp.scope = append(imp.scope, name)
+					}
+				}
+			}
+			break
+		}
+		imports = append(imports, imp)
+	}
+	return imports
+}
+
+// LoadTestGenerics parses the test files of the package with the given import path
+// that are part of the package itself and returns the jigs of the test templates
+// found in them.
+func (p *Package) LoadTestGenerics(path string) ([]*jig, error) {
+	bpkg, present := p.cache.Load(path)
+	if !present {
+		return nil, nil
+	}
+	var jigs []*jig
+	for _, name := range bpkg.(*build.Package).TestGoFiles {
+		file, err := parser.ParseFile(p.Fset, filepath.Join(bpkg.(*build.Package).Dir, name), nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		for _, jig := range p.LoadGenericsFromFile(file, false) {
+			if jig.Test {
+				jigs = append(jigs, jig)
+			}
+		}
+	}
+	return jigs, nil
 }
 
 // sourceCollector is used to visit all the nodes in an ast tree and collect
 // and glue together source fragments that belong to jigs. After the visit is
 // done all jigs have their complete source attached.
 type sourceCollector struct {
-	Fset     *token.FileSet
-	Snippets []*jig
-	Nodoc    bool
+	Fset           *token.FileSet
+	Snippets       []*jig
+	Nodoc          bool
+	LineDirectives bool
+	Imports        []fileImport
+	Decls          []ast.Decl
+	Comments       []*ast.CommentGroup
 }
 
 // Visit a specific ast node and add the source representation of
 // that declaration to the jig for which the Pos and End postion
-// encapsulates the declaration's Pos and End position.
+// encapsulates the declaration's Pos and End position. The comments
+// inside the declaration are part of the source, as are the comments
+// between the declarations of the jig.
 func (c sourceCollector) Visit(node ast.Node) ast.Visitor {
 	if node == nil {
 		return nil
@@ -106,38 +203,143 @@ func (c sourceCollector) Visit(node ast.Node) ast.Visitor {
 		return c
 
 	case *ast.GenDecl, *ast.FuncDecl:
+		doc := docComment(decl)
+		// skip decl doc, but keep directives like //go:noinline
+		var directives []*ast.Comment
+		if c.Nodoc {
+			directives = goDirectives(doc)
+			setDocComment(decl, nil)
+		}
 		for _, jig := range c.Snippets {
 			pos := decl.Pos()
-			// skip decl doc
-			if c.Nodoc {
-				if g, ok := node.(*ast.GenDecl); ok {
-					g.Doc = nil
-				}
-				if f, ok := node.(*ast.FuncDecl); ok {
-					f.Doc = nil
-				}
-			}
 			if jig.ContainsSourceRange(pos, decl.End()) {
+				before, comments, after := c.comments(jig, decl.(ast.Decl), doc)
 				var source bytes.Buffer
-				printer.Fprint(&source, c.Fset, decl)
+				for _, cgroup := range before {
+					fmt.Fprintf(&source, "%s\n\n", commentText(cgroup))
+				}
+				if c.LineDirectives {
+					// Formatting moves the line marker behind the text of the doc comment, in
+					// front of its directives. So point to the first directive or the decl.
+					start := pos
+					if directives := goDirectives(doc); len(directives) > 0 {
+						start = directives[0].Pos()
+					}
+					source.WriteString(jig.AddLine(c.Fset.Position(start)))
+				}
+				for _, directive := range directives {
+					fmt.Fprintf(&source, "%s\n", directive.Text)
+				}
+				printer.Fprint(&source, c.Fset, &printer.CommentedNode{Node: decl, Comments: comments})
+				if c.LineDirectives {
+					fmt.Fprintf(&source, "\n%s", lineEndMarker)
+				}
+				for _, cgroup := range after {
+					fmt.Fprintf(&source, "\n\n%s", commentText(cgroup))
+				}
 				jig.AddSource(source.String())
+				jig.AddImports(decl, c.Imports)
 			}
 		}
 	}
 	return nil
 }
 
+// comments returns the comment groups to print with a declaration of the jig. The free-floating
+// comments of the jig in front of the declaration are returned as before and the comments inside
+// the declaration (including its doc comment) as comments. For the last declaration of the jig,
+// the free-floating comments that follow it are returned as after.
+func (c sourceCollector) comments(jig *jig, decl ast.Decl, doc *ast.CommentGroup) (before, comments, after []*ast.CommentGroup) {
+	beg := decl.Pos()
+	if doc != nil {
+		beg = doc.Pos()
+	}
+	prevEnd, nextBeg := jig.Pos, jig.End
+	for _, d := range c.Decls {
+		if d.End() <= beg && d.End() > prevEnd {
+			prevEnd = d.End()
+		}
+		if d.Pos() > decl.End() {
+			if next := docComment(d); next != nil && next.Pos() < nextBeg {
+				nextBeg = next.Pos()
+			} else if d.Pos() < nextBeg {
+				nextBeg = d.Pos()
+			}
+			break
+		}
+	}
+	for _, cgroup := range c.Comments {
+		switch {
+		case cgroup.Pos() > prevEnd && cgroup.End() < beg:
+			before = append(before, cgroup)
+		case cgroup == doc:
+			if !c.Nodoc {
+				comments = append(comments, doc)
+			}
+		case cgroup.Pos() >= beg && cgroup.End() <= decl.End():
+			comments = append(comments, cgroup)
+		case nextBeg == jig.End && cgroup.Pos() > decl.End() && cgroup.End() < nextBeg:
+			after = append(after, cgroup)
+		}
+	}
+	return before, comments, after
+}
+
+// docComment returns the doc comment of a declaration.
+func docComment(decl ast.Node) *ast.CommentGroup {
+	switch decl := decl.(type) {
+	case *ast.GenDecl:
+		return decl.Doc
+	case *ast.FuncDecl:
+		return decl.Doc
+	}
+	return nil
+}
+
+// setDocComment replaces the doc comment of a declaration.
+func setDocComment(decl ast.Node, doc *ast.CommentGroup) {
+	switch decl := decl.(type) {
+	case *ast.GenDecl:
+		decl.Doc = doc
+	case *ast.FuncDecl:
+		decl.Doc = doc
+	}
+}
+
+// goDirectives returns the directives e.g. //go:noinline found in the doc comment.
+func goDirectives(doc *ast.CommentGroup) []*ast.Comment {
+	if doc == nil {
+		return nil
+	}
+	var directives []*ast.Comment
+	for _, comment := range doc.List {
+		if strings.HasPrefix(comment.Text, "//go:") {
+			directives = append(directives, comment)
+		}
+	}
+	return directives
+}
+
+// commentText returns the source text of the comment group.
+func commentText(cgroup *ast.CommentGroup) string {
+	var lines []string
+	for _, comment := range cgroup.List {
+		lines = append(lines, comment.Text)
+	}
+	return strings.Join(lines, "\n")
+}
+
 // transformCommonIntoNeeds will convert common declarations of a jig
 // into Needs on the other jigs
 func (p *Package) transformCommonIntoNeeds(jigs []*jig) {
 	var common []string
 	for _, jig := range jigs {
-		if jig.common {
+		if jig.Common {
 			common = append(common, jig.Name)
 		}
 	}
 	for _, jig := range jigs {
-		if jig.common {
+		if jig.Common || jig.Test {
 			continue
 		}
 		jig.Needs = append(common, jig.Needs...)
@@ -0,0 +1,343 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/reactivego/jig/templ"
+)
+
+func TestAmbiguousTemplates(t *testing.T) {
+	use := "\nfunc use() {\n\tvar s StringStack\n\ts.Push(\"x\")\n}\n"
+	tests := []struct {
+		name      string
+		app       string
+		source    string
+		ambiguous bool
+	}{{
+		// The library whose import path sorts first is used.
+		name:      "both libraries imported directly",
+		app:       "package app\n\nimport (\n\t_ \"example.com/test/slice\"\n\t_ \"example.com/test/record\"\n)\n" + use,
+		source:    "type StringStack struct{ items []string }",
+		ambiguous: true,
+	}, {
+		name:   "library preferred by jig:prefer",
+		app:    "//jig:prefer example.com/test/slice\n\npackage app\n\nimport (\n\t_ \"example.com/test/slice\"\n\t_ \"example.com/test/record\"\n)\n" + use,
+		source: "type StringStack []string",
+	}, {
+		name:   "library imported directly",
+		app:    "package app\n\nimport _ \"example.com/test/record\"\n" + use,
+		source: "type StringStack struct{ items []string }",
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := writeModule(t, map[string]string{
+				"slice/slice.go":   stackLibrary("slice", ""),
+				"record/record.go": stackLibrary("record", "import _ \"example.com/test/slice\"\n"),
+				"app/app.go":       test.app,
+			})
+			p := modulePackage(t, filepath.Join(dir, "app"), nil)
+			var messages []string
+			errs, err := p.GenerateCode(nil, nil, func(msgs []string) {
+				messages = append(messages, msgs...)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(errs) > 0 {
+				t.Fatal(errs[0])
+			}
+			if reported := strings.Contains(strings.Join(messages, "\n"), "ambiguous"); reported != test.ambiguous {
+				t.Errorf("ambiguity reported: %t; expected %t\n%s", reported, test.ambiguous, strings.Join(messages, "\n"))
+			}
+			if source := fileSource(t, p, p.generated["StringStack"]); !strings.Contains(source, test.source) {
+				t.Errorf("source does not contain %q\n%s", test.source, source)
+			}
+		})
+	}
+}
+
+func TestUnexportedSpecialization(t *testing.T) {
+	// zeroLibrary defines a <Foo>Stack that declares a zero value next to the type.
+	const zeroLibrary = `package lib
+
+type foo interface{}
+
+//jig:template <Foo>Stack
+
+type FooStack []foo
+
+var zeroFoo foo
+
+//jig:template <Foo>Stack Pop
+
+func (s *FooStack) Pop() foo {
+	if len(*s) == 0 {
+		return zeroFoo
+	}
+	v := (*s)[len(*s)-1]
+	*s = (*s)[:len(*s)-1]
+	return v
+}
+`
+	tests := []struct {
+		name      string
+		lib       string
+		use       string
+		fragments []string
+		contains  []string
+		err       string
+	}{{
+		name:      "unexported",
+		lib:       stackLibrary("lib", ""),
+		use:       "var s stringStack\n\ts.Push(\"x\")",
+		fragments: []string{"stringStack", "stringStack_Push"},
+		contains:  []string{"type stringStack struct", "func (s *stringStack) Push(v string)"},
+	}, {
+		name:      "exported",
+		lib:       stackLibrary("lib", ""),
+		use:       "var s StringStack\n\ts.Push(\"x\")",
+		fragments: []string{"StringStack", "StringStack_Push"},
+		contains:  []string{"type StringStack struct", "func (s *StringStack) Push(v string)"},
+	}, {
+		name:      "both without declarations in common",
+		lib:       stackLibrary("lib", ""),
+		use:       "var s stringStack\n\ts.Push(\"x\")\n\tvar t StringStack\n\tt.Push(\"y\")",
+		fragments: []string{"StringStack", "StringStack_Push", "stringStack", "stringStack_Push"},
+		contains:  []string{"type stringStack struct", "type StringStack struct", "func (s *stringStack) Push(v string)", "func (s *StringStack) Push(v string)"},
+	}, {
+		name:      "unexported with declarations",
+		lib:       zeroLibrary,
+		use:       "var s stringStack\n\ts.Pop()",
+		fragments: []string{"stringStack", "stringStack_Pop"},
+		contains:  []string{"type stringStack []string", "var zeroString string", "func (s *stringStack) Pop() string"},
+	}, {
+		name: "both with declarations in common",
+		lib:  zeroLibrary,
+		use:  "var s stringStack\n\ts.Pop()\n\tvar t StringStack\n\tt.Pop()",
+		err:  `cannot generate both "stringStack" and "StringStack", as both would declare zeroString; use only one of them`,
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := writeModule(t, map[string]string{
+				"lib/lib.go": test.lib,
+				"app/app.go": "package app\n\nimport _ \"example.com/test/lib\"\n\nfunc use() {\n\t" + test.use + "\n}\n",
+			})
+			p := modulePackage(t, filepath.Join(dir, "app"), nil)
+			if test.err != "" {
+				if _, err := p.GenerateCode(nil, nil, nil); err == nil || err.Error() != test.err {
+					t.Fatalf("got error %v; expected %s", err, test.err)
+				}
+				return
+			}
+			generateCode(t, p)
+			if fragments := generatedNames(p); !reflect.DeepEqual(fragments, test.fragments) {
+				t.Errorf("generated %q; expected %q", fragments, test.fragments)
+			}
+			source := fileSource(t, p, filepath.Join(dir, "app", "lib.go"))
+			for _, s := range test.contains {
+				if !strings.Contains(source, s) {
+					t.Errorf("source does not contain %q\n%s", s, source)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateMissingTests(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"lib/stack.go":      stackLibrary("lib", ""),
+		"lib/stack_test.go": "package lib\n\nimport \"testing\"\n\n//jig:test <Foo>Stack Push\n\nfunc TestFooStackPush(t *testing.T) {\n\tvar s FooStack\n\tvar v foo\n\ts.Push(v)\n}\n",
+		"app/app.go":        "package app\n\nimport _ \"example.com/test/lib\"\n\nfunc use() {\n\tvar s StringStack\n\ts.Push(\"x\")\n}\n",
+	})
+	// Generate the code without tests first.
+	p := modulePackage(t, filepath.Join(dir, "app"), nil)
+	generateCode(t, p)
+	if _, err := p.WriteGeneratedSources(); err != nil {
+		t.Fatal(err)
+	}
+	if p.HasGeneratedSource("StringStack_Push_test") {
+		t.Fatal("tests generated without WithTests")
+	}
+
+	// Then generate only the missing code, but with tests.
+	p = modulePackage(t, filepath.Join(dir, "app"), func(p *Package) {
+		p.WithTests = true
+	})
+	generateCode(t, p)
+	if !p.HasGeneratedSource("StringStack_Push_test") {
+		t.Fatal("tests not generated for code generated before")
+	}
+	source := fileSource(t, p, filepath.Join(dir, "app", "lib_test.go"))
+	if s := "func TestStringStackPush(t *testing.T)"; !strings.Contains(source, s) {
+		t.Errorf("source does not contain %q\n%s", s, source)
+	}
+}
+
+func TestGenerateSourceError(t *testing.T) {
+	tests := []struct {
+		name     string
+		app      string
+		template bool
+	}{{
+		// The jig:file pragma names a file for the fragments that cannot be expanded.
+		name: "file name",
+		app:  "//jig:file {{index .Types 5}}.go\n\nvar s StringStack\n",
+	}, {
+		// The jig:type pragma maps Bad to a real type that makes the specialized source invalid.
+		name:     "invalid source",
+		app:      "//jig:type Bad int]\n\nvar s BadStack\n",
+		template: true,
+	}, {
+		name: "missing import",
+		app:  "import _ \"example.com/test/missing\"\n\nvar s StringStack\n",
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := writeModule(t, map[string]string{
+				"lib/lib.go": stackLibrary("lib", ""),
+				"app/app.go": "package app\n\nimport _ \"example.com/test/lib\"\n\n" + test.app,
+			})
+			p := modulePackage(t, filepath.Join(dir, "app"), nil)
+			_, err := p.GenerateCode(nil, nil, nil)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			var templateErr *templ.TemplateError
+			if errors.As(err, &templateErr) != test.template {
+				t.Errorf("got error %v; expected a template error: %t", err, test.template)
+			}
+			var loadErr *LoadError
+			if errors.As(err, &loadErr) != (test.name == "missing import") {
+				t.Errorf("got error %v; expected a load error for a missing import only", err)
+			}
+		})
+	}
+}
+
+func TestTemplateComments(t *testing.T) {
+	const commentLibrary = `package lib
+
+type foo interface{}
+
+//jig:template <Foo>Stack
+
+// FooStack is a stack of foo values.
+type FooStack []foo
+
+// zeroFoo is returned when the stack is empty.
+
+var zeroFoo foo
+
+//jig:template <Foo>Stack Pop
+
+// Pop removes the top value from the stack.
+//go:noinline
+func (s *FooStack) Pop() foo {
+	// An empty stack has no top value.
+	if len(*s) == 0 {
+		return zeroFoo
+	}
+	v := (*s)[len(*s)-1] // the top value
+	*s = (*s)[:len(*s)-1]
+	return v
+}
+`
+	tests := []struct {
+		name     string
+		nodoc    bool
+		contains []string
+		omits    []string
+	}{{
+		name: "with documentation",
+		contains: []string{
+			"// StringStack is a stack of string values.\ntype StringStack []string",
+			"// zeroString is returned when the stack is empty.\n\nvar zeroString string",
+			"// Pop removes the top value from the stack.\n",
+			"//go:noinline\nfunc (s *StringStack) Pop() string {",
+			"\t// An empty stack has no top value.\n\tif len(*s) == 0 {",
+			"// the top value\n",
+		},
+	}, {
+		name:  "without documentation",
+		nodoc: true,
+		contains: []string{
+			"//go:noinline\nfunc (s *StringStack) Pop() string {",
+			"\t// An empty stack has no top value.\n\tif len(*s) == 0 {",
+			"// the top value\n",
+			"// zeroString is returned when the stack is empty.\n\nvar zeroString string",
+		},
+		omits: []string{
+			"// StringStack is a stack of string values.",
+			"// Pop removes the top value from the stack.",
+		},
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := writeModule(t, map[string]string{
+				"lib/lib.go": commentLibrary,
+				"app/app.go": "package app\n\nimport _ \"example.com/test/lib\"\n\nfunc use() {\n\tvar s StringStack\n\ts.Pop()\n}\n",
+			})
+			p := modulePackage(t, filepath.Join(dir, "app"), func(p *Package) {
+				p.Nodoc = test.nodoc
+			})
+			generateCode(t, p)
+			source := fileSource(t, p, filepath.Join(dir, "app", "lib.go"))
+			for _, s := range test.contains {
+				if !strings.Contains(source, s) {
+					t.Errorf("source does not contain %q\n%s", s, source)
+				}
+			}
+			for _, s := range test.omits {
+				if strings.Contains(source, s) {
+					t.Errorf("source contains %q\n%s", s, source)
+				}
+			}
+		})
+	}
+}
+
+func TestExplainIdentifier(t *testing.T) {
+	tests := []struct {
+		identifier string
+		signature  string
+	}{{
+		identifier: "StringStackPush",
+		signature:  "StringStack Push",
+	}, {
+		identifier: "StringStack_Push",
+		signature:  "StringStack Push",
+	}, {
+		identifier: "StringStack Push",
+		signature:  "StringStack Push",
+	}, {
+		identifier: "StringStack",
+		signature:  "StringStack",
+	}}
+	dir := writeModule(t, map[string]string{
+		"lib/lib.go": stackLibrary("lib", ""),
+		"app/app.go": "package app\n\nimport _ \"example.com/test/lib\"\n",
+	})
+	p := modulePackage(t, filepath.Join(dir, "app"), nil)
+	checkPackage(t, p)
+	tplr := templ.NewSpecializer()
+	if _, err := p.LoadGenerics(tplr); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		t.Run(test.identifier, func(t *testing.T) {
+			messages, err := tplr.Explain(p, test.identifier)
+			if err != nil {
+				t.Fatal(err)
+			}
+			explanation := strings.Join(messages, "\n")
+			if s := fmt.Sprintf("generating %q would add", test.signature); !strings.Contains(explanation, s) {
+				t.Errorf("explanation does not contain %q\n%s", s, explanation)
+			}
+		})
+	}
+}
@@ -0,0 +1,392 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/token"
+	"io/ioutil"
+	gopath "path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/reactivego/jig/templ"
+	"golang.org/x/tools/go/loader"
+)
+
+// typeParamVars are the template vars given to the type parameters of generic
+// declarations, in order of appearance.
+var typeParamVars = []string{"JigA", "JigB", "JigC", "JigD", "JigE", "JigF", "JigG"}
+
+// LoadTypeParamGenerics turns the generic declarations (declarations with type
+// parameters) of an imported package into jigs, so specialized copies of them can
+// be generated. The type parameters become the template vars of the jig, e.g.
+// "func Map[T, U any]" becomes the template "Map<JigA><JigB>" that matches a
+// reference like "MapIntString". Methods of a generic type become method templates
+// e.g. "func (s *Stack[T]) Push(v T)" becomes "Stack<JigA> Push".
+// Declarations that cannot be specialized on their own, because they refer to
+// unexported names of the package or use other generic declarations without explicit
+// type arguments, are skipped. Returns a message for every skipped declaration.
+func (p *Package) LoadTypeParamGenerics(pkgInfo *loader.PackageInfo) (jigs []*jig, messages []string) {
+	// Find the generic functions and types declared by the package.
+	generics := make(map[string]int)
+	for _, file := range pkgInfo.Files {
+		for _, decl := range file.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				if decl.Recv == nil && decl.Type.TypeParams != nil {
+					generics[decl.Name.Name] = decl.Type.TypeParams.NumFields()
+				}
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					if spec, ok := spec.(*ast.TypeSpec); ok && spec.TypeParams != nil {
+						generics[spec.Name.Name] = spec.TypeParams.NumFields()
+					}
+				}
+			}
+		}
+	}
+	if len(generics) == 0 {
+		return nil, nil
+	}
+
+	for _, file := range pkgInfo.Files {
+		filename := p.Fset.File(file.Pos()).Name()
+		content, err := ioutil.ReadFile(filename)
+		if err != nil {
+			messages = append(messages, fmt.Sprintf("skipping generic declarations in file %q", filename), err.Error())
+			continue
+		}
+		converter := &typeParamConverter{
+			Package:    p,
+			pkg:        pkgInfo,
+			file:       file,
+			content:    content,
+			generics:   generics,
+			unresolved: make(map[*ast.Ident]bool),
+			imports:    p.fileImports(file),
+		}
+		for _, ident := range file.Unresolved {
+			converter.unresolved[ident] = true
+		}
+		for _, decl := range file.Decls {
+			jig, err := converter.convert(decl)
+			if err != nil {
+				messages = append(messages, fmt.Sprintf("skipping generic declaration at %s: %v", p.Fset.Position(decl.Pos()), err))
+			} else if jig != nil {
+				jigs = append(jigs, jig)
+			}
+		}
+	}
+	return jigs, messages
+}
+
+// typeParamConverter converts the generic declarations of a single file into jigs.
+type typeParamConverter struct {
+	*Package
+	pkg     *loader.PackageInfo
+	file    *ast.File
+	content []byte
+
+	// generics maps the names of the generic declarations of the package to
+	// their number of type parameters.
+	generics map[string]int
+
+	// unresolved contains the identifiers that the parser could not resolve
+	// within the file, they refer to package level or universe objects.
+	unresolved map[*ast.Ident]bool
+
+	imports []fileImport
+}
+
+// replacement replaces the source in the range [pos, end) by text.
+type replacement struct {
+	pos, end token.Pos
+	text     string
+}
+
+// templateName returns the name of the template for a generic function or type
+// with the given template vars e.g. "Map<JigA><JigB>".
+func templateName(name string, vars []string) string {
+	for _, tplvar := range vars {
+		name += "<" + tplvar + ">"
+	}
+	return name
+}
+
+// convert returns a jig for the generic declaration. It returns nil without an
+// error when decl is not a generic declaration.
+func (c *typeParamConverter) convert(decl ast.Decl) (*jig, error) {
+	var (
+		name         string
+		doc          *ast.CommentGroup
+		params       []*ast.Ident
+		replacements []replacement
+		needs        []string
+	)
+	switch decl := decl.(type) {
+	case *ast.FuncDecl:
+		doc = decl.Doc
+		if decl.Recv == nil {
+			if decl.Type.TypeParams == nil {
+				return nil, nil
+			}
+			params = fieldNames(decl.Type.TypeParams)
+			if len(params) > len(typeParamVars) {
+				return nil, fmt.Errorf("%s has more than %d type parameters", decl.Name.Name, len(typeParamVars))
+			}
+			vars := typeParamVars[:len(params)]
+			name = templateName(decl.Name.Name, vars)
+			replacements = append(replacements,
+				replacement{decl.Name.Pos(), decl.Name.End(), decl.Name.Name + strings.Join(vars, "")},
+				replacement{decl.Type.TypeParams.Opening, decl.Type.TypeParams.Closing + 1, ""})
+			doc = renamed(doc, decl.Name.Name, decl.Name.Name+strings.Join(vars, ""), &replacements)
+		} else {
+			recv := decl.Recv.List[0].Type
+			if star, ok := recv.(*ast.StarExpr); ok {
+				recv = star.X
+			}
+			typename, indices := indexExpr(recv)
+			if typename == nil || len(indices) == 0 {
+				return nil, nil
+			}
+			for _, index := range indices {
+				param, ok := index.(*ast.Ident)
+				if !ok {
+					return nil, fmt.Errorf("receiver of method %s has unsupported type arguments", decl.Name.Name)
+				}
+				params = append(params, param)
+			}
+			if len(params) > len(typeParamVars) {
+				return nil, fmt.Errorf("%s has more than %d type parameters", typename.Name, len(typeParamVars))
+			}
+			name = templateName(typename.Name, typeParamVars[:len(params)]) + " " + decl.Name.Name
+		}
+	case *ast.GenDecl:
+		if decl.Tok != token.TYPE || len(decl.Specs) != 1 {
+			return nil, nil
+		}
+		spec := decl.Specs[0].(*ast.TypeSpec)
+		if spec.TypeParams == nil {
+			return nil, nil
+		}
+		if decl.Lparen.IsValid() {
+			return nil, fmt.Errorf("generic type %s is declared in a group", spec.Name.Name)
+		}
+		doc = decl.Doc
+		params = fieldNames(spec.TypeParams)
+		if len(params) > len(typeParamVars) {
+			return nil, fmt.Errorf("%s has more than %d type parameters", spec.Name.Name, len(typeParamVars))
+		}
+		vars := typeParamVars[:len(params)]
+		name = templateName(spec.Name.Name, vars)
+		replacements = append(replacements,
+			replacement{spec.Name.Pos(), spec.Name.End(), spec.Name.Name + strings.Join(vars, "")},
+			replacement{spec.TypeParams.Opening, spec.TypeParams.Closing + 1, ""})
+		doc = renamed(doc, spec.Name.Name, spec.Name.Name+strings.Join(vars, ""), &replacements)
+	default:
+		return nil, nil
+	}
+
+	// Map the type parameters to template vars, the real type is the lower case var.
+	vars := make(map[string]string)
+	for i, param := range params {
+		vars[param.Name] = typeParamVars[i]
+	}
+
+	// Replace references to type parameters, generic declarations and package level names.
+	var (
+		err        error
+		selfImport bool
+	)
+	declared := make(map[*ast.Ident]bool)
+	switch decl := decl.(type) {
+	case *ast.FuncDecl:
+		declared[decl.Name] = true
+	case *ast.GenDecl:
+		declared[decl.Specs[0].(*ast.TypeSpec).Name] = true
+	}
+	var visit func(node ast.Node) bool
+	visit = func(node ast.Node) bool {
+		if err != nil {
+			return false
+		}
+		switch n := node.(type) {
+		case *ast.CommentGroup:
+			return false
+		case *ast.FieldList:
+			// The type parameter list is removed.
+			return !isTypeParams(decl, n)
+		case *ast.SelectorExpr:
+			// Only the operand can refer to a package level object.
+			ast.Inspect(n.X, visit)
+			return false
+		case *ast.IndexExpr, *ast.IndexListExpr:
+			x, indices := indexExpr(n.(ast.Expr))
+			if x == nil || !c.packageLevel(x) || c.generics[x.Name] == 0 {
+				return true
+			}
+			var args []string
+			for _, index := range indices {
+				ident, ok := index.(*ast.Ident)
+				if !ok || vars[ident.Name] == "" {
+					err = fmt.Errorf("%s is instantiated with types other than type parameters", x.Name)
+					return false
+				}
+				args = append(args, vars[ident.Name])
+			}
+			replacements = append(replacements, replacement{n.Pos(), n.End(), x.Name + strings.Join(args, "")})
+			if need := templateName(x.Name, args); strings.Fields(name)[0] != need {
+				needs = append(needs, need)
+			}
+			return false
+		case *ast.Ident:
+			if !declared[n] {
+				err = c.replaceIdent(n, vars, &replacements, &selfImport)
+			}
+		}
+		return true
+	}
+	ast.Inspect(decl, visit)
+	if err != nil {
+		return nil, err
+	}
+
+	// Put the source together from the original text with the replacements applied.
+	start := decl.Pos()
+	if doc != nil && !c.Nodoc {
+		start = doc.Pos()
+	}
+	var source bytes.Buffer
+	j := &jig{}
+	j.Name = name
+	j.PackageName = c.pkg.Pkg.Name()
+	for _, tplvar := range typeParamVars[:len(params)] {
+		j.Vars = append(j.Vars, tplvar)
+	}
+	j.RequiredVars = j.Vars
+	j.TypeParams = true
+	j.Needs = needs
+	if c.LineDirectives {
+		source.WriteString(j.AddLine(c.Fset.Position(start)))
+	}
+	sort.Slice(replacements, func(i, k int) bool {
+		return replacements[i].pos < replacements[k].pos
+	})
+	offset := c.Fset.Position(start).Offset
+	for _, r := range replacements {
+		if r.pos < start {
+			continue
+		}
+		source.Write(c.content[offset:c.Fset.Position(r.pos).Offset])
+		source.WriteString(r.text)
+		offset = c.Fset.Position(r.end).Offset
+	}
+	source.Write(c.content[offset:c.Fset.Position(decl.End()).Offset])
+	if c.LineDirectives {
+		fmt.Fprintf(&source, "\n%s", lineEndMarker)
+	}
+	j.AddSource(source.String())
+	j.AddImports(decl, c.imports)
+	if selfImport {
+		imp := templ.Import{Path: c.pkg.Pkg.Path()}
+		if gopath.Base(imp.Path) != c.pkg.Pkg.Name() {
+			imp.Name = c.pkg.Pkg.Name()
+		}
+		j.Imports = append(j.Imports, imp)
+	}
+	return j, nil
+}
+
+// replaceIdent adds the replacement for an identifier referring to a type
+// parameter or to a package level object. Exported package level objects are
+// qualified with the package name, unexported ones and generic declarations
+// without type arguments cannot be referred to from generated code.
+func (c *typeParamConverter) replaceIdent(ident *ast.Ident, vars map[string]string, replacements *[]replacement, selfImport *bool) error {
+	// Type parameters of a method are declared by its receiver and may not be resolved by the parser.
+	if tplvar, present := vars[ident.Name]; present && (ident.Obj == nil || ident.Obj.Kind == ast.Typ) && !c.packageLevel(ident) {
+		*replacements = append(*replacements, replacement{ident.Pos(), ident.End(), strings.ToLower(tplvar)})
+		return nil
+	}
+	if !c.packageLevel(ident) || ident.Name == "_" {
+		return nil
+	}
+	switch {
+	case c.generics[ident.Name] > 0:
+		return fmt.Errorf("%s is used without type arguments", ident.Name)
+	case !ast.IsExported(ident.Name):
+		return fmt.Errorf("refers to unexported %s", ident.Name)
+	}
+	*replacements = append(*replacements, replacement{ident.Pos(), ident.Pos(), c.pkg.Pkg.Name() + "."})
+	*selfImport = true
+	return nil
+}
+
+// packageLevel returns true when the identifier refers to an object declared
+// at the package level of the package.
+func (c *typeParamConverter) packageLevel(ident *ast.Ident) bool {
+	if ident.Obj != nil {
+		return c.file.Scope.Lookup(ident.Name) == ident.Obj
+	}
+	return c.unresolved[ident] && c.pkg.Pkg.Scope().Lookup(ident.Name) != nil
+}
+
+// isTypeParams returns true when the field list is the type parameter list of decl.
+func isTypeParams(decl ast.Decl, fl *ast.FieldList) bool {
+	switch decl := decl.(type) {
+	case *ast.FuncDecl:
+		return decl.Type.TypeParams == fl
+	case *ast.GenDecl:
+		return decl.Specs[0].(*ast.TypeSpec).TypeParams == fl
+	}
+	return false
+}
+
+// indexExpr returns the operand and the indices of an index expression when
+// the operand is an identifier e.g. Pair[K, V] -> Pair, [K, V].
+func indexExpr(expr ast.Expr) (*ast.Ident, []ast.Expr) {
+	switch expr := expr.(type) {
+	case *ast.IndexExpr:
+		if x, ok := expr.X.(*ast.Ident); ok {
+			return x, []ast.Expr{expr.Index}
+		}
+	case *ast.IndexListExpr:
+		if x, ok := expr.X.(*ast.Ident); ok {
+			return x, expr.Indices
+		}
+	}
+	return nil, nil
+}
+
+// fieldNames returns the names declared by the field list in order.
+func fieldNames(fl *ast.FieldList) []*ast.Ident {
+	var names []*ast.Ident
+	for _, field := range fl.List {
+		names = append(names, field.Names...)
+	}
+	return names
+}
+
+// renamed adds replacements renaming the declared name in the doc comment.
+func renamed(doc *ast.CommentGroup, from, to string, replacements *[]replacement) *ast.CommentGroup {
+	if doc == nil {
+		return nil
+	}
+	word := regexp.MustCompile(`\b` + regexp.QuoteMeta(from) + `\b`)
+	for _, comment := range doc.List {
+		if text := word.ReplaceAllString(comment.Text, to); text != comment.Text {
+			*replacements = append(*replacements, replacement{comment.Pos(), comment.End(), text})
+		}
+	}
+	return doc
+}
+
+// isGoroot returns true when the package with the import path is part of the standard library.
+func (p *Package) isGoroot(path string) bool {
+	if bpkg, present := p.cache.Load(path); present {
+		return bpkg.(*build.Package).Goroot
+	}
+	return !strings.Contains(strings.Split(path, "/")[0], ".")
+}
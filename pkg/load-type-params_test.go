@@ -0,0 +1,178 @@
+package pkg
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// typeParamJigs writes the source to a new package dir and returns the jigs for
+// its generic declarations by name, together with the messages for the skipped
+// declarations without their position.
+func typeParamJigs(t *testing.T, source string) (map[string]*jig, []string) {
+	t.Helper()
+	p := modulePackage(t, writeModule(t, map[string]string{"lib.go": source}), nil)
+	if errs := checkPackage(t, p); len(errs) > 0 {
+		t.Fatal(errs[0])
+	}
+	jigs, messages := p.LoadTypeParamGenerics(p.info)
+	named := make(map[string]*jig)
+	for _, jig := range jigs {
+		named[jig.Name] = jig
+	}
+	for i, message := range messages {
+		if i := strings.Index(message, ".go:"); i >= 0 {
+			message = message[strings.Index(message[i:], " ")+i+1:]
+		}
+		messages[i] = message
+	}
+	return named, messages
+}
+
+func TestLoadTypeParamGenerics(t *testing.T) {
+	jigs, messages := typeParamJigs(t, `package lib
+
+import "strconv"
+
+// Pair holds two values.
+type Pair[K, V any] struct {
+	Key   K
+	Value V
+}
+
+// Swap returns the Pair swapped.
+func (p Pair[K, V]) Swap() Pair[V, K] { return Pair[V, K]{p.Value, p.Key} }
+
+// MakePair returns a new Pair.
+func MakePair[K, V any](k K, v V) Pair[K, V] { return Pair[K, V]{k, v} }
+
+// Map applies f to all values.
+func Map[T, U any](values []T, f func(T) U) []U {
+	var result []U
+	for _, v := range values {
+		result = append(result, f(v))
+	}
+	return result
+}
+
+func Itoa[T ~int](v T) string { return strconv.Itoa(int(v)) + Separator }
+
+const Separator = ","
+
+func Identity[T any]() func([]T, func(T) T) []T { return Map[T, T] }
+
+func NotGeneric(v int) int { return v }
+
+func Hidden[T any](v T) T { return hidden(v) }
+
+func hidden[T any](v T) T { return v }
+
+func Ints[T any](v T) Pair[T, int] { return Pair[T, int]{v, 0} }
+
+type (
+	Grouped[T any] []T
+)
+`)
+	tests := []struct {
+		name    string
+		vars    []string
+		needs   []string
+		imports []string
+		source  string
+	}{{
+		name: "Pair<JigA><JigB>",
+		vars: []string{"JigA", "JigB"},
+		source: `// PairJigAJigB holds two values.
+type PairJigAJigB struct {
+	Key   jiga
+	Value jigb
+}
+`,
+	}, {
+		name:  "Pair<JigA><JigB> Swap",
+		vars:  []string{"JigA", "JigB"},
+		needs: []string{"Pair<JigB><JigA>", "Pair<JigB><JigA>"},
+		source: `// Swap returns the Pair swapped.
+func (p PairJigAJigB) Swap() PairJigBJigA { return PairJigBJigA{p.Value, p.Key} }
+`,
+	}, {
+		name:  "MakePair<JigA><JigB>",
+		vars:  []string{"JigA", "JigB"},
+		needs: []string{"Pair<JigA><JigB>", "Pair<JigA><JigB>"},
+		source: `// MakePairJigAJigB returns a new Pair.
+func MakePairJigAJigB(k jiga, v jigb) PairJigAJigB { return PairJigAJigB{k, v} }
+`,
+	}, {
+		name: "Map<JigA><JigB>",
+		vars: []string{"JigA", "JigB"},
+		source: `// MapJigAJigB applies f to all values.
+func MapJigAJigB(values []jiga, f func(jiga) jigb) []jigb {
+	var result []jigb
+	for _, v := range values {
+		result = append(result, f(v))
+	}
+	return result
+}
+`,
+	}, {
+		name:    "Itoa<JigA>",
+		vars:    []string{"JigA"},
+		imports: []string{"strconv", "lib"},
+		source: `func ItoaJigA(v jiga) string { return strconv.Itoa(int(v)) + lib.Separator }
+`,
+	}, {
+		name:  "Identity<JigA>",
+		vars:  []string{"JigA"},
+		needs: []string{"Map<JigA><JigA>"},
+		source: `func IdentityJigA() func([]jiga, func(jiga) jiga) []jiga { return MapJigAJigA }
+`,
+	}, {
+		name: "hidden<JigA>",
+		vars: []string{"JigA"},
+		source: `func hiddenJigA(v jiga) jiga { return v }
+`,
+	}}
+	for _, test := range tests {
+		jig := jigs[test.name]
+		if jig == nil {
+			t.Errorf("no jig for %q", test.name)
+			continue
+		}
+		delete(jigs, test.name)
+		if !jig.TypeParams {
+			t.Errorf("%q: TypeParams not set", test.name)
+		}
+		if fmt.Sprint(jig.Vars) != fmt.Sprint(test.vars) || fmt.Sprint(jig.RequiredVars) != fmt.Sprint(test.vars) {
+			t.Errorf("%q: vars %v, required %v; expected %v", test.name, jig.Vars, jig.RequiredVars, test.vars)
+		}
+		if fmt.Sprint(jig.Needs) != fmt.Sprint(test.needs) {
+			t.Errorf("%q: needs %q; expected %q", test.name, jig.Needs, test.needs)
+		}
+		var imports []string
+		for _, imp := range jig.Imports {
+			if imp.Name != "" {
+				imports = append(imports, imp.Name)
+			} else {
+				imports = append(imports, imp.Path)
+			}
+		}
+		if fmt.Sprint(imports) != fmt.Sprint(test.imports) {
+			t.Errorf("%q: imports %q; expected %q", test.name, imports, test.imports)
+		}
+		if jig.Source != test.source {
+			t.Errorf("%q: got source\n%s\nexpected\n%s", test.name, jig.Source, test.source)
+		}
+	}
+	for name := range jigs {
+		t.Errorf("unexpected jig for %q", name)
+	}
+
+	skipped := []string{
+		"hidden is used without type arguments",
+		"Pair is instantiated with types other than type parameters",
+		"generic type Grouped is declared in a group",
+	}
+	if fmt.Sprintf("%q", messages) != fmt.Sprintf("%q", skipped) {
+		t.Errorf("got messages\n%s\nexpected\n%s", strings.Join(messages, "\n"), strings.Join(skipped, "\n"))
+	}
+}
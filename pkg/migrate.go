@@ -0,0 +1,544 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/reactivego/jig/templ"
+	"golang.org/x/tools/go/ast/astutil"
+	goimports "golang.org/x/tools/imports"
+)
+
+// MigrateGenerics converts the generics of the package to Go code that uses type
+// parameters. A type template (e.g. "<Foo>Stack") becomes a generic type (e.g.
+// "Stack[Foo any]") and its method templates become methods of that type. A
+// function template (e.g. "New<Foo>Stack") becomes a generic function (e.g.
+// "NewStack[Foo any]"). Package level zero values of a template var are moved
+// into the functions that use them. Templates that cannot be expressed with type
+// parameters are left out. Returns the converted source and a report of what was
+// converted and what was not.
+func (p *Package) MigrateGenerics() (source []byte, report []string, err error) {
+	if p.info == nil {
+		return nil, nil, fmt.Errorf("package %q has not been checked", p.Dir)
+	}
+	// Support templates are part of the library being migrated.
+	p.forceCommon = true
+	collector := &genericsCollector{}
+	if _, err := p.LoadGenerics(collector); err != nil {
+		return nil, nil, err
+	}
+	m := &migration{}
+	path := p.info.Pkg.Path()
+	for i, generic := range collector.generics {
+		if generic.PackagePath == path {
+			if err := m.add(generic, collector.sources[i]); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	if len(m.generics) == 0 {
+		return nil, nil, fmt.Errorf("no templates found in package %q (%s)", p.info.Pkg.Name(), path)
+	}
+	m.convert()
+
+	if dirPath, err := importPath(p.Dir); err == nil {
+		path = dirPath
+	}
+	var sourcebuf bytes.Buffer
+	fmt.Fprintf(&sourcebuf, "// Converted by jig migrate from the templates in package %q, review before use.\n\n", path)
+	fmt.Fprintf(&sourcebuf, "package %s\n\n", p.outputName())
+	var imports []string
+	for _, imp := range m.imports() {
+		imports = append(imports, fmt.Sprintf("%s %q", imp.Name, imp.Path))
+	}
+	if len(imports) > 0 {
+		fmt.Fprintf(&sourcebuf, "import (\n%s\n)\n\n", strings.Join(imports, "\n"))
+	}
+	converted := 0
+	for _, g := range m.generics {
+		if g.reason != "" {
+			report = append(report, fmt.Sprintf("not converted %q: %s", g.Name, g.reason))
+			continue
+		}
+		converted++
+		report = append(report, g.message)
+		for _, decl := range g.file.Decls {
+			if err := printer.Fprint(&sourcebuf, g.fset, &printer.CommentedNode{Node: decl, Comments: g.file.Comments}); err != nil {
+				return nil, report, err
+			}
+			sourcebuf.WriteString("\n\n")
+		}
+	}
+	for _, zero := range m.zeros {
+		if zero.used {
+			report = append(report, fmt.Sprintf("moved %s of %q into the functions that use it", zero.name, zero.generic.Name))
+		}
+	}
+	if converted > 0 {
+		report = append(report, "type parameters are constrained by any, tighten the constraints where needed")
+	}
+	report = append(report, fmt.Sprintf("converted %d of %d templates", converted, len(m.generics)))
+
+	source, err = goimports.Process("", sourcebuf.Bytes(), nil)
+	if err != nil {
+		return nil, report, newSourceError(&sourcebuf, err)
+	}
+	return source, report, nil
+}
+
+// genericsCollector is a templ.Specializer that only collects the generics added to it.
+type genericsCollector struct {
+	generics []templ.Generic
+	sources  []string
+}
+
+func (c *genericsCollector) Add(t templ.Generic, source string) error {
+	if t.Test {
+		return nil
+	}
+	c.generics = append(c.generics, t)
+	c.sources = append(c.sources, source)
+	return nil
+}
+
+func (c *genericsCollector) Sort() {}
+
+func (c *genericsCollector) GenerateCodeForType(pkg templ.PackageWriter, signature string) ([]string, error) {
+	return nil, nil
+}
+
+func (c *genericsCollector) Explain(pkg templ.PackageWriter, signature string) ([]string, error) {
+	return nil, nil
+}
+
+func (c *genericsCollector) Graph(pkg templ.PackageWriter, signature string) (*templ.Graph, []string, error) {
+	return &templ.Graph{}, nil, nil
+}
+
+func (c *genericsCollector) Instantiate(pkg templ.PackageWriter, alias, name string, types []string) ([]string, error) {
+	return nil, nil
+}
+
+// migration converts generics into declarations that use type parameters.
+type migration struct {
+	generics []*migrated
+	zeros    []*zeroValue
+}
+
+// migrated is a generic together with the parsed and converted declarations of its source.
+type migrated struct {
+	templ.Generic
+	fset *token.FileSet
+	file *ast.File
+
+	// name is the name of the generic type or function e.g. "Stack" for "<Foo>Stack".
+	name string
+	// declName is the name the template declares e.g. "FooStack" for "<Foo>Stack".
+	declName string
+	// signatures caches the expressions matching names that refer to the generic
+	// type or function, by the template vars that may appear in those names.
+	// e.g. "Foo|Bar" -> ^(Foo|Bar)Stack$ for "<Foo>Stack".
+	signatures map[string]*regexp.Regexp
+
+	// reason explains why the generic cannot be converted, empty when it can.
+	reason string
+	// message describes the result of the conversion.
+	message string
+}
+
+// zeroValue is a package level variable holding the zero value of a template var
+// e.g. "var zeroFoo foo". Generic code has no package level variables of a type
+// parameter, so the variable is declared in the functions that use it instead.
+type zeroValue struct {
+	name    string
+	generic *migrated
+	// pattern matches references to the variable e.g. ^zero([[:word:]]+)$
+	pattern *regexp.Regexp
+	used    bool
+}
+
+// add parses the source of the generic and adds it to the migration.
+func (m *migration) add(generic templ.Generic, source string) error {
+	g := &migrated{Generic: generic, fset: token.NewFileSet()}
+	file, err := parser.ParseFile(g.fset, "", "package migrate\n\n"+source, parser.ParseComments)
+	if err != nil {
+		return newSourceError(bytes.NewBufferString(source), err)
+	}
+	g.file = file
+	g.declName = reTemplateVar.ReplaceAllString(g.Name, "$1")
+	g.name = reTemplateVar.ReplaceAllString(g.Name, "")
+	m.generics = append(m.generics, g)
+	return nil
+}
+
+// convert converts all generics, types and functions first so methods and
+// references can be resolved.
+func (m *migration) convert() {
+	names := make(map[string]*migrated)
+	for _, g := range m.generics {
+		switch {
+		case len(g.Embeds) > 0:
+			g.reason = "jig:embeds cannot be expressed with type parameters"
+		case len(strings.Fields(g.Name)) != 1 || len(g.Vars) == 0:
+			continue
+		case !token.IsIdentifier(g.name):
+			g.reason = fmt.Sprintf("%q is not a valid name without the template vars", g.name)
+		case names[g.name] != nil:
+			g.reason = fmt.Sprintf("name %s is already used by %q", g.name, names[g.name].Name)
+		default:
+			names[g.name] = g
+			g.signatures = make(map[string]*regexp.Regexp)
+		}
+	}
+	var referable []*migrated
+	for _, g := range m.generics {
+		if g.signatures != nil {
+			referable = append(referable, g)
+		}
+	}
+	// Match longer names first, they are more precise.
+	sort.SliceStable(referable, func(i, j int) bool {
+		return len(referable[i].Name) > len(referable[j].Name)
+	})
+	for _, g := range m.generics {
+		if g.reason == "" {
+			m.convertGeneric(g, referable)
+		}
+	}
+	// Generics that failed to convert are not referable after all.
+	for changed := true; changed; {
+		changed = false
+		for _, g := range m.generics {
+			if g.reason == "" && len(g.Vars) > 0 {
+				for _, dep := range m.references(g, referable) {
+					if dep.reason != "" {
+						g.reason = fmt.Sprintf("refers to %q, which is not converted", dep.Name)
+						changed = true
+						break
+					}
+				}
+			}
+		}
+	}
+	m.declareZeroValues()
+}
+
+// convertGeneric converts the declarations of a single generic.
+func (m *migration) convertGeneric(g *migrated, referable []*migrated) {
+	fields := strings.Fields(g.Name)
+	if len(g.Vars) == 0 {
+		g.message = fmt.Sprintf("copied %q, it has no template vars", g.Name)
+		return
+	}
+	if len(fields) == 2 {
+		if reTemplateVar.MatchString(fields[1]) {
+			g.reason = fmt.Sprintf("method %s would need type parameters of its own", reTemplateVar.ReplaceAllString(fields[1], "$1"))
+			return
+		}
+		var recv *migrated
+		for _, r := range referable {
+			if r.Name == fields[0] {
+				recv = r
+			}
+		}
+		if recv == nil || recv.reason != "" {
+			g.reason = fmt.Sprintf("type %q is not converted", fields[0])
+			return
+		}
+	}
+
+	// Replace the template vars and the names of specialized types and functions.
+	for i, decl := range g.file.Decls {
+		g.file.Decls[i] = astutil.Apply(decl, func(c *astutil.Cursor) bool {
+			ident, ok := c.Node().(*ast.Ident)
+			if !ok || declaring(c) {
+				return true
+			}
+			for _, tplvar := range g.Vars {
+				if ident.Name == strings.ToLower(tplvar) {
+					ident.Name = tplvar
+					return true
+				}
+			}
+			if r, types := matchGeneric(referable, ident.Name, g.Vars); r != nil {
+				c.Replace(instance(ident, r.name, types))
+			}
+			return true
+		}, nil).(ast.Decl)
+	}
+
+	// Turn the declaration of the type or function into a generic one.
+	var decls []ast.Decl
+	declared := len(fields) == 2
+	for _, decl := range g.file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if decl.Recv == nil && decl.Name.Name == g.declName && len(fields) == 1 {
+				decl.Name.Name = g.name
+				decl.Type.TypeParams = typeParams(g.Vars)
+				rename(decl.Doc, g.declName, g.name)
+				g.message = fmt.Sprintf("converted %q to func %s[%s any]", g.Name, g.name, strings.Join(g.Vars, ", "))
+				declared = true
+			} else if decl.Recv == nil && dependsOnVars(decl.Name.Name, g.Vars) {
+				g.reason = fmt.Sprintf("func %s depends on the template vars", decl.Name.Name)
+				return
+			} else if decl.Recv != nil && len(fields) == 2 {
+				g.message = fmt.Sprintf("converted %q to method %s.%s", g.Name, reTemplateVar.ReplaceAllString(fields[0], ""), decl.Name.Name)
+			}
+			decls = append(decls, decl)
+		case *ast.GenDecl:
+			var specs []ast.Spec
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					if spec.Name.Name == g.declName && len(fields) == 1 {
+						spec.Name.Name = g.name
+						spec.TypeParams = typeParams(g.Vars)
+						rename(decl.Doc, g.declName, g.name)
+						rename(spec.Doc, g.declName, g.name)
+						g.message = fmt.Sprintf("converted %q to type %s[%s any]", g.Name, g.name, strings.Join(g.Vars, ", "))
+						declared = true
+					} else if dependsOnVars(spec.Name.Name, g.Vars) {
+						g.reason = fmt.Sprintf("type %s depends on the template vars", spec.Name.Name)
+						return
+					}
+				case *ast.ValueSpec:
+					if zero := m.zeroValue(g, decl.Tok, spec); zero != nil {
+						m.zeros = append(m.zeros, zero)
+						continue
+					}
+					for _, name := range spec.Names {
+						if dependsOnVars(name.Name, g.Vars) {
+							g.reason = fmt.Sprintf("%s %s depends on the template vars", decl.Tok, name.Name)
+							return
+						}
+					}
+				}
+				specs = append(specs, spec)
+			}
+			if len(specs) > 0 {
+				decl.Specs = specs
+				decls = append(decls, decl)
+			}
+		default:
+			decls = append(decls, decl)
+		}
+	}
+	if !declared {
+		g.reason = fmt.Sprintf("template does not declare %s", g.declName)
+		return
+	}
+	if g.message == "" {
+		g.message = fmt.Sprintf("converted %q", g.Name)
+	}
+	g.file.Decls = decls
+}
+
+// declaring returns true when the identifier at the cursor declares or selects
+// a name, instead of referring to a declared name.
+func declaring(c *astutil.Cursor) bool {
+	switch c.Name() {
+	case "Name", "Names", "Sel", "Label":
+		return true
+	}
+	return false
+}
+
+// matchGeneric returns the generic the name refers to when all types matched from the
+// name are template vars, together with the matched types.
+// e.g. "FooStack" with vars ["Foo"] -> <Foo>Stack, ["Foo"]
+func matchGeneric(referable []*migrated, name string, vars []string) (*migrated, []string) {
+	var alternatives []string
+	for _, tplvar := range vars {
+		alternatives = append(alternatives, regexp.QuoteMeta(tplvar))
+	}
+	key := strings.Join(alternatives, "|")
+	for _, r := range referable {
+		signature, present := r.signatures[key]
+		if !present {
+			expr := regexp.QuoteMeta(r.Name)
+			for _, tplvar := range r.Vars {
+				expr = strings.Replace(expr, regexp.QuoteMeta(fmt.Sprintf("<%s>", tplvar)), "("+key+")", 1)
+			}
+			signature = regexp.MustCompile("^" + expr + "$")
+			r.signatures[key] = signature
+		}
+		if sigmatch := signature.FindStringSubmatch(name); sigmatch != nil {
+			return r, sigmatch[1:]
+		}
+	}
+	return nil, nil
+}
+
+// containsAll returns true when all strings in sel are also present in set.
+func containsAll(set, sel []string) bool {
+	for _, s := range sel {
+		found := false
+		for _, e := range set {
+			found = found || e == s
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// instance returns the expression instantiating the generic type or function
+// with the given name for the types, e.g. Stack[Foo].
+func instance(ident *ast.Ident, name string, types []string) ast.Expr {
+	var indices []ast.Expr
+	for _, typ := range types {
+		indices = append(indices, &ast.Ident{NamePos: ident.End(), Name: typ})
+	}
+	x := &ast.Ident{NamePos: ident.Pos(), Name: name}
+	if len(indices) == 1 {
+		return &ast.IndexExpr{X: x, Lbrack: ident.End(), Index: indices[0], Rbrack: ident.End()}
+	}
+	return &ast.IndexListExpr{X: x, Lbrack: ident.End(), Indices: indices, Rbrack: ident.End()}
+}
+
+// typeParams returns the type parameter list for the template vars.
+func typeParams(vars []string) *ast.FieldList {
+	var names []*ast.Ident
+	known := make(map[string]bool)
+	for _, tplvar := range vars {
+		if !known[tplvar] {
+			known[tplvar] = true
+			names = append(names, ast.NewIdent(tplvar))
+		}
+	}
+	return &ast.FieldList{List: []*ast.Field{{Names: names, Type: ast.NewIdent("any")}}}
+}
+
+// rename replaces the name in the text of the doc comment.
+func rename(doc *ast.CommentGroup, from, to string) {
+	if doc == nil {
+		return
+	}
+	word := regexp.MustCompile(`\b` + regexp.QuoteMeta(from) + `\b`)
+	for _, comment := range doc.List {
+		comment.Text = word.ReplaceAllString(comment.Text, to)
+	}
+}
+
+// dependsOnVars returns true when the name contains one of the template vars.
+func dependsOnVars(name string, vars []string) bool {
+	for _, tplvar := range vars {
+		if strings.Contains(name, tplvar) {
+			return true
+		}
+	}
+	return false
+}
+
+// zeroValue returns the zero value declared by spec, or nil when spec does not
+// declare a single variable of a template var without a value.
+func (m *migration) zeroValue(g *migrated, tok token.Token, spec *ast.ValueSpec) *zeroValue {
+	ident, ok := spec.Type.(*ast.Ident)
+	if tok != token.VAR || !ok || len(spec.Names) != 1 || len(spec.Values) != 0 {
+		return nil
+	}
+	for _, tplvar := range g.Vars {
+		name := spec.Names[0].Name
+		if ident.Name == tplvar && strings.Count(name, tplvar) == 1 {
+			expr := strings.Replace(regexp.QuoteMeta(name), tplvar, "([[:word:]]+)", 1)
+			return &zeroValue{name: name, generic: g, pattern: regexp.MustCompile("^" + expr + "$")}
+		}
+	}
+	return nil
+}
+
+// declareZeroValues declares the zero values used by the functions of the
+// converted generics at the start of those functions.
+func (m *migration) declareZeroValues() {
+	for _, g := range m.generics {
+		if g.reason != "" || len(g.Vars) == 0 {
+			continue
+		}
+		for _, decl := range g.file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Body == nil {
+				continue
+			}
+			var stmts []ast.Stmt
+			known := make(map[string]bool)
+			ast.Inspect(fn.Body, func(node ast.Node) bool {
+				ident, ok := node.(*ast.Ident)
+				if !ok || known[ident.Name] {
+					return true
+				}
+				for _, zero := range m.zeros {
+					if sigmatch := zero.pattern.FindStringSubmatch(ident.Name); sigmatch != nil && containsAll(g.Vars, sigmatch[1:]) {
+						zero.used = true
+						known[ident.Name] = true
+						stmts = append(stmts, &ast.DeclStmt{Decl: &ast.GenDecl{
+							TokPos: fn.Body.Lbrace,
+							Tok:    token.VAR,
+							Specs: []ast.Spec{&ast.ValueSpec{
+								Names: []*ast.Ident{{NamePos: fn.Body.Lbrace, Name: ident.Name}},
+								Type:  ast.NewIdent(sigmatch[1]),
+							}},
+						}})
+						break
+					}
+				}
+				return true
+			})
+			fn.Body.List = append(stmts, fn.Body.List...)
+		}
+	}
+}
+
+// references returns the referable generics that the converted declarations of g refer to.
+func (m *migration) references(g *migrated, referable []*migrated) []*migrated {
+	var refs []*migrated
+	ast.Inspect(g.file, func(node ast.Node) bool {
+		var x ast.Expr
+		switch n := node.(type) {
+		case *ast.IndexExpr:
+			x = n.X
+		case *ast.IndexListExpr:
+			x = n.X
+		}
+		ident, ok := x.(*ast.Ident)
+		if !ok {
+			return true
+		}
+		for _, r := range referable {
+			if r.name == ident.Name && r != g {
+				refs = append(refs, r)
+			}
+		}
+		return true
+	})
+	return refs
+}
+
+// imports returns the imports of the converted generics, sorted by path.
+func (m *migration) imports() []templ.Import {
+	var imports []templ.Import
+	known := make(map[templ.Import]bool)
+	for _, g := range m.generics {
+		if g.reason != "" {
+			continue
+		}
+		for _, imp := range g.Imports {
+			if !known[imp] {
+				known[imp] = true
+				imports = append(imports, imp)
+			}
+		}
+	}
+	sort.Slice(imports, func(i, j int) bool {
+		return imports[i].Path < imports[j].Path
+	})
+	return imports
+}
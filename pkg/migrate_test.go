@@ -0,0 +1,202 @@
+package pkg
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// migratedPackage writes the templates to a new package dir together with the
+// declarations of the types foo and bar used as template vars and migrates the
+// generics of the package.
+func migratedPackage(t *testing.T, templates string) (string, []string, error) {
+	t.Helper()
+	p := modulePackage(t, writeModule(t, map[string]string{
+		"templates.go": "package lib\n\n" + templates,
+		"types.go":     "package lib\n\ntype foo interface{}\n\ntype bar interface{}\n",
+	}), nil)
+	if errs := checkPackage(t, p); len(errs) > 0 {
+		t.Fatal(errs[0])
+	}
+	source, report, err := p.MigrateGenerics()
+	// Leave out the first line, it contains the package dir.
+	if i := strings.Index(string(source), "\n\n"); i >= 0 {
+		source = source[i+2:]
+	}
+	return string(source), report, err
+}
+
+const stackTemplates = `//jig:template <Foo>Stack
+
+type FooStack []foo
+
+var zeroFoo foo
+
+//jig:template <Foo>Stack Pop
+
+// Pop removes the top of the FooStack.
+func (s *FooStack) Pop() (foo, bool) {
+	if len(*s) == 0 {
+		return zeroFoo, false
+	}
+	v := (*s)[len(*s)-1]
+	*s = (*s)[:len(*s)-1]
+	return v, true
+}
+
+//jig:template New<Foo>Stack
+
+// NewFooStack returns a new FooStack.
+func NewFooStack() *FooStack { return &FooStack{} }
+`
+
+func TestMigrateGenerics(t *testing.T) {
+	tests := []struct {
+		name      string
+		templates string
+		source    string
+		report    []string
+	}{{
+		name:      "type, method and function",
+		templates: stackTemplates,
+		source: `package lib
+
+type Stack[Foo any] []Foo
+
+// Pop removes the top of the FooStack.
+func (s *Stack[Foo]) Pop() (Foo, bool) {
+	var zeroFoo Foo
+	if len(*s) == 0 {
+		return zeroFoo, false
+	}
+	v := (*s)[len(*s)-1]
+	*s = (*s)[:len(*s)-1]
+	return v, true
+}
+
+// NewStack returns a new FooStack.
+func NewStack[Foo any]() *Stack[Foo] { return &Stack[Foo]{} }
+`,
+		report: []string{
+			`converted "<Foo>Stack" to type Stack[Foo any]`,
+			`converted "<Foo>Stack Pop" to method Stack.Pop`,
+			`converted "New<Foo>Stack" to func NewStack[Foo any]`,
+			`moved zeroFoo of "<Foo>Stack" into the functions that use it`,
+			"type parameters are constrained by any, tighten the constraints where needed",
+			"converted 3 of 3 templates",
+		},
+	}, {
+		name: "several template vars",
+		templates: `//jig:template <Foo><Bar>Pair
+
+type FooBarPair struct {
+	First  foo
+	Second bar
+}
+
+//jig:template Make<Foo><Bar>Pair
+
+func MakeFooBarPair(first foo, second bar) FooBarPair { return FooBarPair{first, second} }
+`,
+		source: `package lib
+
+type Pair[Foo, Bar any] struct {
+	First  Foo
+	Second Bar
+}
+
+func MakePair[Foo, Bar any](first Foo, second Bar) Pair[Foo, Bar] {
+	return Pair[Foo, Bar]{first, second}
+}
+`,
+		report: []string{
+			`converted "<Foo><Bar>Pair" to type Pair[Foo, Bar any]`,
+			`converted "Make<Foo><Bar>Pair" to func MakePair[Foo, Bar any]`,
+			"type parameters are constrained by any, tighten the constraints where needed",
+			"converted 2 of 2 templates",
+		},
+	}, {
+		name: "templates without template vars are copied",
+		templates: `//jig:template Max
+
+func Max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+`,
+		source: `package lib
+
+func Max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+`,
+		report: []string{
+			`copied "Max", it has no template vars`,
+			"type parameters are constrained by any, tighten the constraints where needed",
+			"converted 1 of 1 templates",
+		},
+	}, {
+		name: "templates that cannot be converted",
+		templates: stackTemplates + `
+//jig:template <Foo>Stack <Bar>Map
+
+func (s FooStack) BarMap(f func(foo) bar) []bar { return nil }
+
+//jig:template <Foo>Embed
+//jig:embeds <Foo>Stack
+
+type FooEmbed struct{ FooStack }
+
+//jig:template <Foo>List
+
+type FooList struct{ head *FooListNode }
+
+type FooListNode struct {
+	value foo
+	next  *FooListNode
+}
+
+//jig:template New<Foo>List
+
+func NewFooList() *FooList { return &FooList{} }
+`,
+		report: []string{
+			`converted "<Foo>Stack" to type Stack[Foo any]`,
+			`converted "<Foo>Stack Pop" to method Stack.Pop`,
+			`converted "New<Foo>Stack" to func NewStack[Foo any]`,
+			`not converted "<Foo>Stack <Bar>Map": method BarMap would need type parameters of its own`,
+			`not converted "<Foo>Embed": jig:embeds cannot be expressed with type parameters`,
+			`not converted "<Foo>List": type FooListNode depends on the template vars`,
+			`not converted "New<Foo>List": refers to "<Foo>List", which is not converted`,
+			`moved zeroFoo of "<Foo>Stack" into the functions that use it`,
+			"type parameters are constrained by any, tighten the constraints where needed",
+			"converted 3 of 7 templates",
+		},
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source, report, err := migratedPackage(t, test.templates)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.source != "" && source != test.source {
+				t.Errorf("got source\n%s\nexpected\n%s", source, test.source)
+			}
+			if fmt.Sprintf("%q", report) != fmt.Sprintf("%q", test.report) {
+				t.Errorf("got report\n%s\nexpected\n%s", strings.Join(report, "\n"), strings.Join(test.report, "\n"))
+			}
+		})
+	}
+}
+
+func TestMigrateGenericsWithoutTemplates(t *testing.T) {
+	_, _, err := migratedPackage(t, "func Max(a, b int) int { return a }\n")
+	if err == nil || !strings.HasPrefix(err.Error(), "no templates found") {
+		t.Errorf("got error %v; expected no templates found", err)
+	}
+}
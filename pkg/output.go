@@ -0,0 +1,281 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/token"
+	"go/types"
+	"io"
+	"io/ioutil"
+	"os"
+	gopath "path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+	goimports "golang.org/x/tools/imports"
+)
+
+// outputDir returns the directory where generated source fragments are written.
+func (p *Package) outputDir() string {
+	if p.OutputDir != "" {
+		return p.OutputDir
+	}
+	return p.Dir
+}
+
+// outputName returns the name of the package generated source fragments are part of.
+func (p *Package) outputName() string {
+	if p.OutputDir != "" {
+		return p.OutputName
+	}
+	return p.Name
+}
+
+// parseOutputDir will add the .go files found in the output directory to the
+// internal list of files. Files with generated source are detected so they can
+// be regenerated. The output directory does not need to exist yet.
+func (p *Package) parseOutputDir() error {
+	if p.OutputName == "" {
+		p.OutputName = filepath.Base(p.OutputDir)
+	}
+	path, err := importPath(p.OutputDir)
+	if err != nil {
+		return err
+	}
+	p.outputPath = path
+
+	filepaths, err := filepath.Glob(filepath.Join(p.OutputDir, "*.go"))
+	if err != nil {
+		return err
+	}
+	for _, path := range filepaths {
+		file, err := p.Config.ParseFile(path, nil)
+		if err != nil {
+			return err
+		}
+		if strings.HasSuffix(path, "_test.go") {
+			// Only tests generated by jig are part of the output package.
+			if file.Name.String() == p.OutputName {
+				p.ScanForGeneratedSources(file)
+			}
+			continue
+		}
+		if file.Name.String() != p.OutputName {
+			return fmt.Errorf("file %q is in package %q, expected package %q", path, file.Name, p.OutputName)
+		}
+		p.AddFile(file)
+		p.ScanForGeneratedSources(file)
+	}
+	return nil
+}
+
+// importPath determines the import path for a directory by looking for the
+// go.mod file of the module the directory is part of. Without a go.mod file
+// the directory must be part of a GOPATH workspace.
+func importPath(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for root := abs; ; root = filepath.Dir(root) {
+		if content, err := ioutil.ReadFile(filepath.Join(root, "go.mod")); err == nil {
+			if match := reModulePath.FindSubmatch(content); match != nil {
+				rel, err := filepath.Rel(root, abs)
+				if err != nil {
+					return "", err
+				}
+				module := strings.Trim(string(match[1]), `"`)
+				return gopath.Join(module, filepath.ToSlash(rel)), nil
+			}
+		}
+		if filepath.Dir(root) == root {
+			break
+		}
+	}
+	for _, gopathDir := range filepath.SplitList(build.Default.GOPATH) {
+		src := filepath.Join(gopathDir, "src") + string(filepath.Separator)
+		if strings.HasPrefix(abs, src) {
+			return filepath.ToSlash(strings.TrimPrefix(abs, src)), nil
+		}
+	}
+	return "", fmt.Errorf("cannot determine import path for directory %q", dir)
+}
+
+var reModulePath = regexp.MustCompile(`(?m)^module[[:space:]]+([^[:space:]]+)`)
+
+// outputFiles returns the paths of the files that are part of the output package, sorted by path.
+func (p *Package) outputFiles() []string {
+	var paths []string
+	for path, file := range p.fileset {
+		if filepath.Dir(path) == filepath.Clean(p.OutputDir) && file.Name.String() == p.OutputName {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// outputStub is the name of the file served for the output package when it
+// does not contain any files yet. It is never written to disk.
+const outputStub = "_jig.go"
+
+// findOutputPackage returns the build package for the output package. The
+// files of the package are served from memory by openFile.
+func (p *Package) findOutputPackage() *build.Package {
+	bpkg := &build.Package{
+		Dir:        p.OutputDir,
+		Name:       p.OutputName,
+		ImportPath: p.outputPath,
+	}
+	for _, path := range p.outputFiles() {
+		bpkg.GoFiles = append(bpkg.GoFiles, filepath.Base(path))
+	}
+	if len(bpkg.GoFiles) == 0 {
+		bpkg.GoFiles = []string{outputStub}
+	}
+	return bpkg
+}
+
+// openFile opens the file with the given path for the loader. Files of the
+// output package are served from memory, because they may not have been
+// written to disk yet.
+func (p *Package) openFile(path string) (io.ReadCloser, error) {
+	if p.OutputDir != "" && filepath.Dir(path) == filepath.Clean(p.OutputDir) {
+		if filepath.Base(path) == outputStub {
+			return ioutil.NopCloser(strings.NewReader("package " + p.OutputName + "\n")), nil
+		}
+		if file, present := p.fileset[path]; present {
+			var source bytes.Buffer
+			if err := p.WriteFile(&source, file); err != nil {
+				return nil, err
+			}
+			return ioutil.NopCloser(&source), nil
+		}
+	}
+	return os.Open(path)
+}
+
+// RewriteReferences makes the package use the types and functions generated into
+// the output package. Every identifier reported as undefined by the errors that is
+// now declared by the output package is qualified with the name of the output
+// package, and the output package is imported. Returns a message for every file
+// that was rewritten.
+func (p *Package) RewriteReferences(errs []error) (messages []string, err error) {
+	if p.OutputDir == "" {
+		return nil, nil
+	}
+	declared := make(map[string]bool)
+	for _, path := range p.outputFiles() {
+		for _, decl := range p.fileset[path].Decls {
+			for _, name := range declaredNames(decl) {
+				declared[name] = ast.IsExported(name)
+			}
+		}
+	}
+
+	// Collect the positions of the identifiers to qualify per file.
+	positions := make(map[string]map[token.Pos]bool)
+	for _, err := range errs {
+		terr, ok := err.(types.Error)
+		if !ok {
+			continue
+		}
+		match := reUndefinedName.FindStringSubmatch(terr.Msg)
+		if match == nil || !declared[match[1]] {
+			continue
+		}
+		path := p.Fset.File(terr.Pos).Name()
+		if file, present := p.fileset[path]; present && file.Name.String() == p.Name {
+			if positions[path] == nil {
+				positions[path] = make(map[token.Pos]bool)
+			}
+			positions[path][terr.Pos] = true
+		}
+	}
+
+	var paths []string
+	for path := range positions {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if err := p.qualifyIdentifiers(path, positions[path]); err != nil {
+			return messages, err
+		}
+		p.rewritten[path] = struct{}{}
+		messages = append(messages, fmt.Sprintf("rewriting %d references in file %q to package %q", len(positions[path]), path, p.outputPath))
+	}
+	return messages, nil
+}
+
+// qualifyIdentifiers puts the name of the output package in front of the
+// identifiers at the given positions in the file and imports the output package.
+func (p *Package) qualifyIdentifiers(path string, positions map[token.Pos]bool) error {
+	file := astutil.Apply(p.fileset[path], nil, func(c *astutil.Cursor) bool {
+		if ident, ok := c.Node().(*ast.Ident); ok && positions[ident.Pos()] {
+			c.Replace(&ast.SelectorExpr{X: ast.NewIdent(p.OutputName), Sel: ident})
+		}
+		return true
+	}).(*ast.File)
+	if p.OutputName == gopath.Base(p.outputPath) {
+		astutil.AddImport(p.Fset, file, p.outputPath)
+	} else {
+		astutil.AddNamedImport(p.Fset, file, p.OutputName, p.outputPath)
+	}
+
+	var sourcebuf bytes.Buffer
+	if err := p.WriteFile(&sourcebuf, file); err != nil {
+		return err
+	}
+	fixedsource, err := goimports.Process(path, sourcebuf.Bytes(), nil)
+	if err != nil {
+		return newSourceError(&sourcebuf, err)
+	}
+	file, err = p.Config.ParseFile(path, fixedsource)
+	if err != nil {
+		return newSourceError(bytes.NewBuffer(fixedsource), err)
+	}
+	p.AddFile(file)
+	return nil
+}
+
+// declaredNames returns the names of the package level identifiers declared by decl.
+func declaredNames(decl ast.Decl) []string {
+	var names []string
+	switch decl := decl.(type) {
+	case *ast.FuncDecl:
+		if decl.Recv == nil {
+			names = append(names, decl.Name.Name)
+		}
+	case *ast.GenDecl:
+		for _, spec := range decl.Specs {
+			switch spec := spec.(type) {
+			case *ast.TypeSpec:
+				names = append(names, spec.Name.Name)
+			case *ast.ValueSpec:
+				for _, name := range spec.Names {
+					names = append(names, name.Name)
+				}
+			}
+		}
+	}
+	return names
+}
+
+// unqualify removes the name of the output package from the type in the signature.
+// e.g. "stackgen.StringStack Pop" -> "StringStack Pop"
+func (p *Package) unqualify(signature string) string {
+	if p.OutputDir == "" {
+		return signature
+	}
+	return strings.TrimPrefix(signature, p.OutputName+".")
+}
+
+// reUndefinedName matches the message of an error about an undefined name.
+// Matches will contain the name e.g. ["undefined: StringStack", "StringStack"]
+var reUndefinedName = regexp.MustCompile(`^(?:undefined|undeclared name): ([[:word:]]+)$`)
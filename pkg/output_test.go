@@ -0,0 +1,77 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerateIntoOutputPackage(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"lib/stack.go": stackLibrary("lib", ""),
+		"app/app.go":   "package app\n\nimport _ \"example.com/test/lib\"\n\nfunc use() {\n\tvar s StringStack\n\ts.Push(\"x\")\n}\n",
+	})
+	// Run in the package dir with the output dir relative to it, like "jig -o ../stackgen".
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+	if err := os.Chdir(filepath.Join(dir, "app")); err != nil {
+		t.Fatal(err)
+	}
+	p := NewPackage(".")
+	p.OutputDir = filepath.Join("..", "stackgen")
+	if err := p.ParseDir(); err != nil {
+		t.Fatal(err)
+	}
+	generateCode(t, p)
+	tests := []struct {
+		name     string
+		path     string
+		contains []string
+	}{{
+		name: "output package",
+		path: filepath.Join("..", "stackgen", "lib.go"),
+		contains: []string{
+			"//go:generate jig -o . --pkg stackgen ../app\n\npackage stackgen\n",
+			"type StringStack struct",
+			"func (s *StringStack) Push(v string)",
+		},
+	}, {
+		name: "references",
+		path: "app.go",
+		contains: []string{
+			`"example.com/test/stackgen"`,
+			"var s stackgen.StringStack",
+		},
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source := fileSource(t, p, test.path)
+			for _, s := range test.contains {
+				if !strings.Contains(source, s) {
+					t.Errorf("source does not contain %q\n%s", s, source)
+				}
+			}
+		})
+	}
+
+	// The go:generate directive finds the package again when run from the output dir.
+	if _, err := p.WriteGeneratedSources(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(filepath.Join(dir, "stackgen")); err != nil {
+		t.Fatal(err)
+	}
+	q := NewPackage(filepath.Join("..", "app"))
+	q.OutputDir = "."
+	q.OutputName = "stackgen"
+	if err := q.ParseDir(); err != nil {
+		t.Fatal(err)
+	}
+	if !q.HasGeneratedSource("StringStack") || !q.HasGeneratedSource("StringStack_Push") {
+		t.Errorf("generated source not found by package run from the output dir")
+	}
+}
@@ -1,26 +1,45 @@
 package pkg
 
 import (
-	"log"
+	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
 // ParseDir will add .go files found in the package directory to the internal list of files.
-// This will also detect if a file contains previously generated source
+// This will also detect if a file contains previously generated source. A file that cannot
+// be parsed is reported as LoadError.
 func (p *Package) ParseDir() error {
+	if err := p.parseDir(); err != nil {
+		return &LoadError{Dir: p.Dir, Err: err}
+	}
+	return nil
+}
+
+func (p *Package) parseDir() error {
 
 	// Load all go files in folder indicated by path
 	filepaths, err := filepath.Glob(filepath.Join(p.Dir, "*.go"))
 	if err != nil {
 		return err
 	}
+	for path := range p.Overlay {
+		if _, err := os.Stat(path); os.IsNotExist(err) && filepath.Dir(path) == filepath.Clean(p.Dir) && strings.HasSuffix(path, ".go") {
+			filepaths = append(filepaths, path)
+		}
+	}
+	sort.Strings(filepaths)
 
 	// Parse the list of filenames into a list of ast.File objects.
 	for _, path := range filepaths {
-		file, err := p.Config.ParseFile(path, nil)
+		var src interface{}
+		if contents, present := p.Overlay[path]; present {
+			src = contents
+		}
+		file, err := p.Config.ParseFile(path, src)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 
 		// Get the package name from the first package name stored in the files.
@@ -47,5 +66,9 @@ func (p *Package) ParseDir() error {
 		p.ScanForGeneratedSources(file)
 	}
 
+	// Also parse the files of the package source is generated into.
+	if p.OutputDir != "" {
+		return p.parseOutputDir()
+	}
 	return nil
 }
@@ -5,6 +5,8 @@ import (
 	"go/build"
 	"go/parser"
 	"go/types"
+	"path/filepath"
+	"sort"
 	"sync"
 	"text/template"
 
@@ -22,25 +24,101 @@ type Package struct {
 	// Name is the package name found in the first source file that is scanned from the package dir.
 	Name string
 
+	// OutputDir is the directory of a separate package to generate source into. When empty,
+	// source is generated into the package dir itself.
+	OutputDir string
+
+	// OutputName is the name of the package in OutputDir. Defaults to the base name of OutputDir.
+	OutputName string
+
 	// Nodoc removes documentation from generated sources.
 	Nodoc bool
 
+	// LineDirectives adds //line directives to generated sources that point
+	// back to the positions of the templates in the template library.
+	LineDirectives bool
+
+	// WithTests generates the tests of specialized templates into _test.go files.
+	WithTests bool
+
+	// PruneExported allows pruning exported generated code that is not referenced by the
+	// package, but may be referenced by other packages.
+	PruneExported bool
+
+	// DryRun prevents files from being written to or removed from the package dir.
+	DryRun bool
+
+	// Command is the command written in the go:generate directive of generated files.
+	// e.g. "jig -n"
+	Command string
+
+	// Overlay maps the paths of files in the package dir to contents to use instead of
+	// the contents on disk, e.g. those of the unsaved buffers of an editor. Files in the
+	// overlay that do not exist on disk yet are part of the package too.
+	Overlay map[string][]byte
+
+	// Events, when set, is called with a structured record of everything jig does to
+	// the package, e.g. for every fragment generated and every file written. The
+	// messages returned for what an event records are built from the event itself.
+	// Messages remain for what has no event, like pragmas that are ignored, and for
+	// what the templ.Specializer reports, as it knows nothing of packages and events.
+	Events func(Event)
+
 	// generated maps source fragment name to filepath
 	generated map[string]string
 
 	// fileset maps filepath to file instance.
 	fileset map[string]*ast.File
 
+	// disk contains the paths of files that contained generated source when
+	// the package dir was parsed.
+	disk map[string]struct{}
+
+	// outputPath is the import path of the package in OutputDir.
+	outputPath string
+
+	// rewritten contains the paths of files of the package itself that were
+	// rewritten to refer to source generated into the package in OutputDir.
+	rewritten map[string]struct{}
+
+	// removed contains the paths of files removed from the fileset that
+	// still have to be removed from disk.
+	removed map[string]struct{}
+
 	// allPackages is populated by checking the loaded source code.
 	allPackages []*loader.PackageInfo
 
+	// info is the type checked package info of the package itself. It is
+	// populated by checking the loaded source code.
+	info *loader.PackageInfo
+
 	// filename template for where source fragments are to be generated.
 	// Depending on the given template, this may be a single file or multiple files.
 	filename *template.Template
 
+	// header is the template for the banner written at the top of generated files.
+	header *template.Template
+
+	// maxLines caps the number of lines in a generated file, 0 means no cap.
+	maxLines int
+
 	// typemap contains a mapping of display types e.g. Foo to real types e.g. foo
 	typemap map[string]string
 
+	// keep contains the fragment name patterns given by jig:keep pragmas.
+	keep []string
+
+	// instantiate contains the values of jig:instantiate pragmas.
+	instantiate []string
+
+	// interfaceMethods contains the names of all methods of interface types
+	// in the loaded packages. Populated on demand when pruning.
+	interfaceMethods map[string]struct{}
+
+	// prefer contains the import paths of template libraries given by jig:prefer
+	// pragmas, in order of preference.
+	prefer []string
+
 	// forceCommon (default set to false) forces common code templates (i.e. not
 	// specialized on type) to be included in the generated source code. Common
 	// code normally assumed to be present already in the package providing the
@@ -61,13 +139,6 @@ func NewPackage(dir string) *Package {
 
 	conf := &loader.Config{
 		TypeChecker: types.Config{Error: func(err error) {}},
-		TypeCheckFuncBodies: func(path string) bool {
-			// only check function bodies in our own directory.
-			if path == dir {
-				return true
-			}
-			return false
-		},
 		ParserMode:  parser.ParseComments,
 		AllowErrors: true,
 		Build:       &buildContext,
@@ -77,13 +148,28 @@ func NewPackage(dir string) *Package {
 		Config:    conf,
 		generated: make(map[string]string),
 		fileset:   make(map[string]*ast.File),
+		disk:      make(map[string]struct{}),
+		removed:   make(map[string]struct{}),
+		rewritten: make(map[string]struct{}),
 		filename:  template.Must(template.New("filename").Parse("{{.package}}.go")),
 		typemap:   make(map[string]string),
+		Command:   "jig",
+	}
+	pkg.Config.TypeCheckFuncBodies = func(path string) bool {
+		// only check function bodies in our own directory and the output package.
+		if path == dir || (pkg.OutputDir != "" && path == pkg.outputPath) {
+			return true
+		}
+		return false
 	}
+	buildContext.OpenFile = pkg.openFile
 	pkg.Config.FindPackage = func(ctxt *build.Context, importPath, fromDir string, mode build.ImportMode) (*build.Package, error) {
-		if bpkg, present := pkg.cache.Load(importPath); present {
+		if pkg.OutputDir != "" && importPath == pkg.outputPath {
+			// The output package changes while generating, so never cache it.
+			return pkg.findOutputPackage(), nil
+		} else if bpkg, present := pkg.cache.Load(importPath); present {
 			return bpkg.(*build.Package), nil
-		} else if bpkg, err := ctxt.Import(importPath, fromDir, mode); err == nil {
+		} else if bpkg, err := importContext(ctxt).Import(importPath, fromDir, mode); err == nil {
 			pkg.cache.Store(importPath, bpkg)
 			return bpkg, nil
 		} else {
@@ -95,10 +181,16 @@ func NewPackage(dir string) *Package {
 }
 
 // PkgSpec returns PkgSpec instances with Path and Files correctly initialized.
+// Files are sorted by path, so the package is always loaded in the same way.
 func (p *Package) PkgSpec() []loader.PkgSpec {
+	var paths []string
+	for path := range p.fileset {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
 	var files []*ast.File
-	for _, file := range p.fileset {
-		if p.Name == file.Name.String() {
+	for _, path := range paths {
+		if file := p.fileset[path]; p.Name == file.Name.String() && filepath.Dir(path) == filepath.Clean(p.Dir) {
 			files = append(files, file)
 		}
 	}
@@ -109,6 +201,26 @@ func (p *Package) Typemap() map[string]string {
 	return p.typemap
 }
 
+// Prefer returns the import paths of template libraries given by jig:prefer
+// pragmas, in order of preference.
+func (p *Package) Prefer() []string {
+	return p.prefer
+}
+
+// Imports returns true when the package directly imports the package with the
+// given import path.
+func (p *Package) Imports(path string) bool {
+	if p.info == nil {
+		return false
+	}
+	for _, imported := range p.info.Pkg.Imports() {
+		if imported.Path() == path {
+			return true
+		}
+	}
+	return false
+}
+
 // Filepath will return the filepath for a given *ast.File param.
 func (p *Package) Filepath(file *ast.File) string {
 	return p.Fset.File(file.Package).Name()
@@ -131,3 +243,15 @@ func (p *Package) GeneratedFileset() map[*ast.File]struct{} {
 	}
 	return fileset
 }
+
+// importContext returns the context to use for finding packages. A context
+// with an OpenFile hook disables support for modules in go/build, so the hook
+// for serving the output package from memory is left out.
+func importContext(ctxt *build.Context) *build.Context {
+	if ctxt.OpenFile == nil {
+		return ctxt
+	}
+	importCtxt := *ctxt
+	importCtxt.OpenFile = nil
+	return &importCtxt
+}
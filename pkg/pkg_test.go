@@ -0,0 +1,117 @@
+package pkg
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// generatedHeader is the header of a file containing generated source.
+const generatedHeader = "// Code generated by jig; DO NOT EDIT.\n\n//go:generate jig\n\n"
+
+// writeModule writes the files to a new dir containing the module "example.com/test".
+// The paths of the files are relative to the module dir. Returns the module dir.
+func writeModule(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	files["go.mod"] = "module example.com/test\n"
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// modulePackage creates the package for the dir in a module written by writeModule
+// and parses its files. Imports are resolved from the module, as jig does when it is
+// run in the package dir.
+func modulePackage(t *testing.T, dir string, configure func(*Package)) *Package {
+	t.Helper()
+	p := NewPackage(dir)
+	p.Build.Dir = dir
+	if configure != nil {
+		configure(p)
+	}
+	if err := p.ParseDir(); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// checkPackage type checks the package and returns the errors that were found.
+func checkPackage(t *testing.T, p *Package) []error {
+	t.Helper()
+	errs, err := p.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return errs
+}
+
+// generateCode generates the code for the package in memory the way jig gen does,
+// failing the test when not every error could be fixed.
+func generateCode(t *testing.T, p *Package) {
+	t.Helper()
+	errs, err := p.GenerateCode(nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) > 0 {
+		t.Fatal(errs[0])
+	}
+}
+
+// generatedNames returns the sorted names of the generated fragments of the package.
+func generatedNames(p *Package) []string {
+	var names []string
+	for name := range p.generated {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// fileSource returns the source of the file with the given path in the package.
+func fileSource(t *testing.T, p *Package, path string) string {
+	t.Helper()
+	file, present := p.fileset[path]
+	if !present {
+		t.Fatalf("file %q not found", path)
+	}
+	var source bytes.Buffer
+	if err := p.WriteFile(&source, file); err != nil {
+		t.Fatal(err)
+	}
+	return source.String()
+}
+
+// stackLibrary returns the source of a template library with the given package name
+// and imports, defining a <Foo>Stack with a Push method. The stack type is a slice
+// for package "slice" and a struct for any other package.
+func stackLibrary(name, imports string) string {
+	typ := "[]foo"
+	push := "*s = append(*s, v)"
+	if name != "slice" {
+		typ = "struct{ items []foo }"
+		push = "s.items = append(s.items, v)"
+	}
+	return "package " + name + "\n\n" + imports + `
+type foo interface{}
+
+//jig:template <Foo>Stack
+
+type FooStack ` + typ + `
+
+//jig:template <Foo>Stack Push
+
+func (s *FooStack) Push(v foo) { ` + push + ` }
+`
+}
@@ -6,6 +6,13 @@ import "regexp"
 // It also marks the end of any previous template.
 const jigTemplate = "//jig:template"
 
+// jigTest is the jig:test comment pragma that defines the start of the template of a test.
+// The test template is found in a _test.go file of the template library and has the same
+// name as the template it tests. When that template is specialized with the --with-tests
+// flag given, the test template is specialized too and written into a _test.go file.
+// e.g. //jig:test <Foo>Stack Pop
+const jigTest = "//jig:test"
+
 // jigCommon is the jig:common comment pragma that marks this template as purely
 // for common support code needed by every generated template.
 const jigCommon = "//jig:common"
@@ -34,12 +41,43 @@ const jigEnd = "//jig:end"
 // the package that contains the jig template being expanded and Name is the signature of the source fragment
 // being expanded. Capitalized variables are created via strings.Title(var) and lowecase variant are create via
 // strings.ToLower(var) allowing full control over the generated filename.
+// Also available are {{.Template}} and {{.template}} for the name of the template being expanded, {{.Type}} and
+// {{.type}} for the type being specialized, {{.Types}} and {{.Vars}} for the list of types bound to the list of
+// template vars and {{.Path}} for the import path of the package that contains the template.
 // Examples:
 // 	jig.go				  	 -> jig.go
 //  jig{{.Package}}.go	 	 -> jigRx.go
 //  {{.package}}{{.Name}}.go -> rxObservableInt32.go
+//  {{.package}}_{{.type}}.go -> rx_observableint32.go
 const jigFile = "//jig:file"
 
+// jigLayout represents comment pragma //jig:layout and is used in the code that is type checked.
+// It selects one of the built-in layouts for the files that fragments are generated into:
+//	package  -> {{.package}}.go               e.g. rx.go (default)
+//	template -> {{.package}}_{{.template}}.go e.g. rx_observablefoo_mapbar.go
+//	type     -> {{.package}}_{{.type}}.go     e.g. rx_observableint.go
+// The type layout puts a specialized type together with all of its methods in a single file.
+const jigLayout = "//jig:layout"
+
+// jigMaxLines represents comment pragma //jig:max-lines and is used in the code that is type checked.
+// It caps the number of lines of a generated file. Fragments that would make a file grow beyond the
+// cap are generated into the next file of a series e.g. rx.go, rx_2.go, rx_3.go etc.
+// e.g. //jig:max-lines 2000
+const jigMaxLines = "//jig:max-lines"
+
+// jigHeader represents comment pragma //jig:header and is used in the code that is type checked.
+// Every jig:header pragma adds a line to the banner written at the top of every generated file,
+// in front of the line marking the file as generated.
+// e.g. //jig:header Copyright 2021 The Authors. All rights reserved.
+const jigHeader = "//jig:header"
+
+// jigHeaderFile represents comment pragma //jig:header-file and is used in the code that is type
+// checked. It names a file, relative to the package dir, containing the banner to write at the top of
+// every generated file. The file is a text/template that can use the variables {{.Package}} for the
+// name of the package and {{.Command}} for the command in the go:generate directive.
+// e.g. //jig:header-file ../LICENSE.header
+const jigHeaderFile = "//jig:header-file"
+
 // jigType comment pragma allows specifying the real type for a display type. It is used in
 // code that is type checked. Useful for specializing generic code for unexported types.
 // e.g. //jig:type Woot woot
@@ -53,12 +91,43 @@ const jigType = "//jig:type"
 // template named "Observable<Foo> Map<Bar>" and using types int32 and float32 for Foo and Bar respectively.
 const jigName = "//jig:name"
 
+// jigPrefer represents comment pragma //jig:prefer and is used in the code that is type checked.
+// When templates with the same name are defined in several imported template libraries, the
+// template from the library with the given import path is used. Multiple jig:prefer pragmas
+// may be given, earlier ones take precedence over later ones. Without this pragma a library
+// that is imported directly by the package is preferred over one that is imported indirectly.
+// e.g. //jig:prefer github.com/reactivego/rx/generic
+const jigPrefer = "//jig:prefer"
+
+// jigKeep represents comment pragma //jig:keep and is used in the code that is type checked.
+// It pins generated source fragments so they are never pruned, even when nothing references
+// them. The value is a comma separated list of fragment names as written in the jig:name
+// pragma. Names may contain the wildcards supported by path.Match.
+// e.g. //jig:keep StringStack_Pop, ObservableInt*
+const jigKeep = "//jig:keep"
+
+// jigInstantiate represents comment pragma //jig:instantiate and is used in the code that is type checked.
+// It specializes a template for one or more lists of types, without the need for code that refers to the
+// result. For the template of a type, the templates of the methods of that type are specialized as well.
+// The types for a template with several template vars are separated by spaces. The second form takes the
+// types between square brackets and generates an alias when the name differs from the specialized name.
+// e.g. //jig:instantiate <Foo>Stack Int, String
+//      //jig:instantiate MyInts = <Foo>Stack[Int]
+const jigInstantiate = "//jig:instantiate"
+
 // jigForceCommon pragma instructs jig to always generate common support code.
 const jigForceCommon = "//jig:force-common-code-generation"
 
 // jigNoDoc pragma instructs jig to not include documentation in the generated code.
 const jigNoDoc = "//jig:no-doc"
 
+// layouts maps the names of the built-in layouts selected by jig:layout to filename templates.
+var layouts = map[string]string{
+	"package":  "{{.package}}.go",
+	"template": "{{.package}}_{{.template}}.go",
+	"type":     "{{.package}}_{{.type}}.go",
+}
+
 var (
 	reCommentPragma = regexp.MustCompile("^(//jig:[-[:word:]]+)[[:space:]]+(.+)$")
 
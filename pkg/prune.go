@@ -0,0 +1,352 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	gopath "path"
+	"sort"
+	"strings"
+
+	goimports "golang.org/x/tools/imports"
+)
+
+// fragment is a generated source fragment as found in a file that contains
+// generated source. A fragment starts at its jig:name pragma and ends where
+// the next fragment starts.
+type fragment struct {
+	name string
+	file *ast.File
+	pos  token.Pos
+	end  token.Pos
+
+	// defs contains the package level objects declared by the fragment.
+	defs []types.Object
+}
+
+// PruneGeneratedSources removes the generated source fragments that are no longer
+// referenced by the code of the package. Fragments referenced by user code or by
+// other live fragments are kept, as are fragments pinned by a jig:keep pragma.
+// A method is also kept when its receiver type is live and some interface has a
+// method by that name, because the method may be needed to satisfy it. Exported
+// fragments may be used by other packages, so they are kept too unless the package
+// is a command or PruneExported is set.
+// Pruning relies on complete type information, so it is skipped when the package
+// has type errors.
+func (p *Package) PruneGeneratedSources() (messages []string, err error) {
+	if p.info == nil {
+		return nil, nil
+	}
+	if len(p.info.Errors) > 0 {
+		return []string{"skipping prune, package has errors"}, nil
+	}
+
+	// Find the fragments in the files that contain generated source.
+	var fragments []*fragment
+	byfile := make(map[string][]*fragment)
+	for file := range p.GeneratedFileset() {
+		path := p.Filepath(file)
+		for _, frag := range p.fragments(file) {
+			fragments = append(fragments, frag)
+			byfile[path] = append(byfile[path], frag)
+		}
+	}
+	if len(fragments) == 0 {
+		return nil, nil
+	}
+	// The position is not adjusted by //line directives, these point into the templates.
+	fragmentAt := func(pos token.Pos) *fragment {
+		for _, frag := range byfile[p.Fset.File(pos).Name()] {
+			if pos >= frag.pos && pos < frag.end {
+				return frag
+			}
+		}
+		return nil
+	}
+
+	// Map the objects declared by fragments to those fragments.
+	defined := make(map[types.Object]*fragment)
+	methods := make(map[types.Object][]*fragment)
+	for _, frag := range fragments {
+		for _, obj := range frag.defs {
+			defined[obj] = frag
+		}
+	}
+	for _, frag := range fragments {
+		for _, obj := range frag.defs {
+			if recv := receiver(obj); recv != nil {
+				methods[recv] = append(methods[recv], frag)
+			}
+		}
+	}
+
+	// Build the references between fragments and collect the fragments
+	// referenced directly by user code.
+	live := make(map[*fragment]bool)
+	var queue []*fragment
+	mark := func(frag *fragment) {
+		if frag != nil && !live[frag] {
+			live[frag] = true
+			queue = append(queue, frag)
+		}
+	}
+	refs := make(map[*fragment][]*fragment)
+	for ident, obj := range p.info.Uses {
+		to := defined[obj]
+		if to == nil {
+			continue
+		}
+		if from := fragmentAt(ident.Pos()); from == nil {
+			mark(to)
+		} else if from != to {
+			refs[from] = append(refs[from], to)
+		}
+	}
+	pruneExported := p.PruneExported || p.info.Pkg.Name() == "main"
+	for _, frag := range fragments {
+		if len(frag.defs) == 0 || p.keeps(frag.name) {
+			mark(frag)
+		}
+		if !pruneExported && exported(frag) && !strings.HasSuffix(p.Filepath(frag.file), "_test.go") {
+			mark(frag)
+		}
+		// Methods on types not generated by jig are live when the type is.
+		for _, obj := range frag.defs {
+			if recv := receiver(obj); recv != nil && defined[recv] == nil && p.implements(obj.Name()) {
+				mark(frag)
+			}
+		}
+	}
+
+	// Propagate liveness through the references.
+	for len(queue) > 0 {
+		frag := queue[0]
+		queue = queue[1:]
+		for _, to := range refs[frag] {
+			mark(to)
+		}
+		for _, obj := range frag.defs {
+			for _, method := range methods[obj] {
+				for _, m := range method.defs {
+					if receiver(m) == obj && p.implements(m.Name()) {
+						mark(method)
+					}
+				}
+			}
+		}
+	}
+
+	// Generated tests do not keep the code they test alive, but are live as long
+	// as all of that code is.
+	for _, frag := range fragments {
+		if !strings.HasSuffix(p.Filepath(frag.file), "_test.go") || len(refs[frag]) == 0 {
+			continue
+		}
+		live[frag] = true
+		for _, to := range refs[frag] {
+			if !live[to] {
+				live[frag] = false
+				break
+			}
+		}
+	}
+
+	// Remove the fragments that are not live.
+	for path, frags := range byfile {
+		var dead []*fragment
+		for _, frag := range frags {
+			if !live[frag] {
+				dead = append(dead, frag)
+			}
+		}
+		if len(dead) == 0 {
+			continue
+		}
+		for _, frag := range dead {
+			messages = append(messages, fmt.Sprintf("pruning %q from file %q", frag.name, path))
+			delete(p.generated, frag.name)
+		}
+		if len(dead) == len(frags) {
+			msgs, err := p.RemoveFileset(map[*ast.File]struct{}{frags[0].file: {}})
+			messages = append(messages, msgs...)
+			if err != nil {
+				return messages, err
+			}
+			continue
+		}
+		if err := p.removeFragments(path, frags[0].file, dead); err != nil {
+			return messages, err
+		}
+	}
+	sort.Strings(messages)
+
+	// Make sure the package still builds after pruning.
+	errs, err := p.Check()
+	if err != nil {
+		return messages, err
+	}
+	if len(errs) > 0 {
+		return messages, fmt.Errorf("pruning would break the package: %v", errs[0])
+	}
+	return messages, nil
+}
+
+// fragments returns the generated source fragments found in the file.
+func (p *Package) fragments(file *ast.File) []*fragment {
+	var fragments []*fragment
+	for _, cgroup := range file.Comments {
+		for _, comment := range cgroup.List {
+			kvmatch := reCommentPragma.FindStringSubmatch(comment.Text)
+			if len(kvmatch) == 3 && kvmatch[1] == jigName {
+				if n := len(fragments); n > 0 {
+					fragments[n-1].end = cgroup.Pos()
+				}
+				fragments = append(fragments, &fragment{name: kvmatch[2], file: file, pos: cgroup.Pos()})
+			}
+		}
+	}
+	if n := len(fragments); n > 0 {
+		fragments[n-1].end = file.End() + 1
+	}
+	for _, decl := range file.Decls {
+		for _, frag := range fragments {
+			if decl.Pos() >= frag.pos && decl.Pos() < frag.end {
+				frag.defs = append(frag.defs, p.declared(decl)...)
+				break
+			}
+		}
+	}
+	return fragments
+}
+
+// declared returns the package level objects and methods declared by decl.
+func (p *Package) declared(decl ast.Decl) []types.Object {
+	var idents []*ast.Ident
+	switch decl := decl.(type) {
+	case *ast.FuncDecl:
+		idents = append(idents, decl.Name)
+	case *ast.GenDecl:
+		for _, spec := range decl.Specs {
+			switch spec := spec.(type) {
+			case *ast.TypeSpec:
+				idents = append(idents, spec.Name)
+			case *ast.ValueSpec:
+				idents = append(idents, spec.Names...)
+			}
+		}
+	}
+	var objs []types.Object
+	for _, ident := range idents {
+		if obj := p.info.Defs[ident]; obj != nil && ident.Name != "_" && ident.Name != "init" {
+			objs = append(objs, obj)
+		}
+	}
+	return objs
+}
+
+// exported returns true when the fragment declares an object other packages can refer
+// to, i.e. an exported object or an exported method of an exported type.
+func exported(frag *fragment) bool {
+	for _, obj := range frag.defs {
+		if recv := receiver(obj); obj.Exported() && (recv == nil || recv.Exported()) {
+			return true
+		}
+	}
+	return false
+}
+
+// receiver returns the type name object of the receiver when obj is a method.
+func receiver(obj types.Object) types.Object {
+	fn, ok := obj.(*types.Func)
+	if !ok {
+		return nil
+	}
+	recv := fn.Type().(*types.Signature).Recv()
+	if recv == nil {
+		return nil
+	}
+	typ := recv.Type()
+	if ptr, ok := typ.(*types.Pointer); ok {
+		typ = ptr.Elem()
+	}
+	if named, ok := typ.(*types.Named); ok {
+		return named.Obj()
+	}
+	return nil
+}
+
+// implements returns true when an interface type in any of the loaded packages
+// has a method with the given name.
+func (p *Package) implements(method string) bool {
+	if p.interfaceMethods == nil {
+		p.interfaceMethods = make(map[string]struct{})
+		for _, pkgInfo := range p.allPackages {
+			for _, tv := range pkgInfo.Types {
+				if tv.Type == nil {
+					continue
+				}
+				if iface, ok := tv.Type.Underlying().(*types.Interface); ok {
+					for i := 0; i < iface.NumMethods(); i++ {
+						p.interfaceMethods[iface.Method(i).Name()] = struct{}{}
+					}
+				}
+			}
+		}
+	}
+	_, present := p.interfaceMethods[method]
+	return present
+}
+
+// keeps returns true when the fragment name matches a jig:keep pragma.
+func (p *Package) keeps(name string) bool {
+	for _, pattern := range p.keep {
+		if matched, _ := gopath.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// removeFragments removes the declarations and comments of the dead fragments
+// from the file and replaces the file in the fileset with the result.
+func (p *Package) removeFragments(path string, file *ast.File, dead []*fragment) error {
+	contains := func(pos token.Pos) bool {
+		for _, frag := range dead {
+			if pos >= frag.pos && pos < frag.end {
+				return true
+			}
+		}
+		return false
+	}
+	var decls []ast.Decl
+	for _, decl := range file.Decls {
+		if !contains(decl.Pos()) {
+			decls = append(decls, decl)
+		}
+	}
+	var comments []*ast.CommentGroup
+	for _, cgroup := range file.Comments {
+		if !contains(cgroup.Pos()) {
+			comments = append(comments, cgroup)
+		}
+	}
+	file.Decls, file.Comments = decls, comments
+
+	var sourcebuf bytes.Buffer
+	if err := p.WriteFile(&sourcebuf, file); err != nil {
+		return err
+	}
+	// Remove imports that are no longer used.
+	fixedsource, err := goimports.Process("", sourcebuf.Bytes(), nil)
+	if err != nil {
+		return newSourceError(&sourcebuf, err)
+	}
+	pruned, err := p.Config.ParseFile(path, string(fixedsource))
+	if err != nil {
+		return newSourceError(bytes.NewBuffer(fixedsource), err)
+	}
+	p.AddFile(pruned)
+	return nil
+}
@@ -0,0 +1,95 @@
+package pkg
+
+import (
+	"strings"
+	"testing"
+)
+
+// prunedPackage writes the files to a new package dir, prunes the generated source
+// fragments and returns the names of the fragments that are left.
+func prunedPackage(t *testing.T, files map[string]string, pruneExported bool) []string {
+	t.Helper()
+	p := modulePackage(t, writeModule(t, files), func(p *Package) {
+		p.PruneExported = pruneExported
+	})
+	if errs := checkPackage(t, p); len(errs) > 0 {
+		t.Fatal(errs[0])
+	}
+	p.LoadGeneratePragmas()
+	if _, err := p.PruneGeneratedSources(); err != nil {
+		t.Fatal(err)
+	}
+	return generatedNames(p)
+}
+
+func TestPruneGeneratedSources(t *testing.T) {
+	stack := generatedHeader + `package %s
+
+//jig:name IntStack
+
+type IntStack []int
+
+//jig:name IntStack_Push
+
+func (s *IntStack) Push(v int) { *s = append(*s, v) }
+
+//jig:name IntStack_Pop
+
+func (s *IntStack) Pop() int { v := (*s)[len(*s)-1]; *s = (*s)[:len(*s)-1]; return v }
+
+//jig:name intStack_top
+
+func (s *IntStack) top() int { return last(*s) }
+
+//jig:name last
+
+func last(s []int) int { return s[len(s)-1] }
+
+//jig:name unused
+
+//line template.go:1
+func unused() int { return helper() }
+
+//jig:name helper
+
+//line template.go:10
+func helper() int { return 42 }
+`
+	tests := []struct {
+		name          string
+		pkg           string
+		keep          string
+		pruneExported bool
+		live          []string
+	}{{
+		name: "exported fragments are kept in a library",
+		pkg:  "lib",
+		live: []string{"IntStack", "IntStack_Pop", "IntStack_Push"},
+	}, {
+		name:          "exported fragments are pruned with PruneExported",
+		pkg:           "lib",
+		pruneExported: true,
+		live:          []string{"IntStack", "IntStack_Push"},
+	}, {
+		name: "exported fragments are pruned in a command",
+		pkg:  "main",
+		live: []string{"IntStack", "IntStack_Push"},
+	}, {
+		name: "kept fragments keep their references alive",
+		pkg:  "main",
+		keep: "//jig:keep intStack_*\n\n",
+		live: []string{"IntStack", "IntStack_Push", "intStack_top", "last"},
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			files := map[string]string{
+				"use.go":   test.keep + "package " + test.pkg + "\n\nfunc use() {\n\tvar s IntStack\n\ts.Push(1)\n}\n",
+				"stack.go": strings.Replace(stack, "%s", test.pkg, 1),
+			}
+			live := prunedPackage(t, files, test.pruneExported)
+			if strings.Join(live, ",") != strings.Join(test.live, ",") {
+				t.Errorf("live fragments %v; expected %v", live, test.live)
+			}
+		})
+	}
+}
@@ -3,11 +3,11 @@ package pkg
 import (
 	"fmt"
 	"go/ast"
-	"os"
 )
 
 // RemoveGeneratedSources will remove all files that contain
-// generated source from the package dir.
+// generated source from the package. The files are not removed
+// from the package dir until the generated sources are written.
 func (p *Package) RemoveGeneratedSources() ([]string, error) {
 	fileset := p.GeneratedFileset()
 
@@ -21,15 +21,14 @@ func (p *Package) RemoveGeneratedSources() ([]string, error) {
 }
 
 // RemoveFileset will remove the passed set of files from the PkgSpec files slice.
+// The files are remembered so WriteFileset can remove them from disk, unless
+// they have been generated again by then.
 func (p *Package) RemoveFileset(fileset map[*ast.File]struct{}) (messages []string, err error) {
 	for path, file := range p.fileset {
 		_, present := fileset[file]
 		if present {
-			messages = append(messages, fmt.Sprintf("removing file %q", path))
-			// Remove physical files on disk.
-			if e := os.Remove(path); e != nil {
-				err = e
-			}
+			messages = append(messages, fmt.Sprintf("dropping file %q", path))
+			p.removed[path] = struct{}{}
 			delete(p.fileset, path)
 		}
 	}
@@ -0,0 +1,149 @@
+package pkg
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"sort"
+	"strings"
+
+	goimports "golang.org/x/tools/imports"
+)
+
+// SortGeneratedSources rewrites every file containing generated source so its
+// fragments appear in a deterministic order, independent of the order in which
+// the fragments were generated. Fragments declaring types come first, followed
+// by fragments declaring functions, variables or constants and finally fragments
+// declaring methods. Fragments of the same kind are sorted by name.
+func (p *Package) SortGeneratedSources() error {
+	for file := range p.GeneratedFileset() {
+		if err := p.sortFragments(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sortedFragment is the source of a fragment together with its sort keys.
+type sortedFragment struct {
+	name   string
+	kind   int
+	source string
+}
+
+// Kinds of fragments in the order in which they appear in a file.
+const (
+	typeFragment = iota
+	funcFragment
+	methodFragment
+)
+
+// sortFragments sorts the fragments in a single file and replaces the file in
+// the fileset with the result. The file is left alone when it is already sorted.
+func (p *Package) sortFragments(file *ast.File) error {
+	var sourcebuf bytes.Buffer
+	if err := p.WriteFile(&sourcebuf, file); err != nil {
+		return err
+	}
+	source := sourcebuf.Bytes()
+
+	// Parse the printed source again so positions match offsets in the source.
+	fset := token.NewFileSet()
+	printed, err := parser.ParseFile(fset, "", source, parser.ParseComments)
+	if err != nil {
+		return newSourceError(&sourcebuf, err)
+	}
+	offset := func(pos token.Pos) int {
+		return fset.Position(pos).Offset
+	}
+
+	// Find the start of every fragment.
+	var fragments []*sortedFragment
+	var starts []int
+	for _, cgroup := range printed.Comments {
+		for _, comment := range cgroup.List {
+			kvmatch := reCommentPragma.FindStringSubmatch(comment.Text)
+			if len(kvmatch) == 3 && kvmatch[1] == jigName {
+				fragments = append(fragments, &sortedFragment{name: kvmatch[2], kind: typeFragment})
+				starts = append(starts, offset(cgroup.Pos()))
+			}
+		}
+	}
+	if len(fragments) < 2 {
+		return nil
+	}
+	starts = append(starts, len(source))
+	for i, fragment := range fragments {
+		fragment.source = strings.TrimSpace(string(source[starts[i]:starts[i+1]]))
+	}
+
+	// Determine the kind of every fragment from its declarations.
+	kinds := make([]int, len(fragments))
+	for i := range kinds {
+		kinds[i] = -1
+	}
+	for _, decl := range printed.Decls {
+		i := sort.SearchInts(starts, offset(decl.Pos())+1) - 1
+		if i < 0 || i >= len(fragments) {
+			continue
+		}
+		kind := funcFragment
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if decl.Recv != nil {
+				kind = methodFragment
+			}
+		case *ast.GenDecl:
+			if decl.Tok == token.TYPE {
+				kind = typeFragment
+			} else if decl.Tok == token.IMPORT {
+				continue
+			}
+		}
+		if kinds[i] == -1 || kind < kinds[i] {
+			kinds[i] = kind
+		}
+	}
+	for i, fragment := range fragments {
+		if kinds[i] != -1 {
+			fragment.kind = kinds[i]
+		}
+	}
+
+	sorted := make([]*sortedFragment, len(fragments))
+	copy(sorted, fragments)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].kind != sorted[j].kind {
+			return sorted[i].kind < sorted[j].kind
+		}
+		return sorted[i].name < sorted[j].name
+	})
+	unchanged := true
+	for i := range sorted {
+		unchanged = unchanged && sorted[i] == fragments[i]
+	}
+	if unchanged {
+		return nil
+	}
+
+	// Put the header back together with the sorted fragments.
+	sortedbuf := &bytes.Buffer{}
+	sortedbuf.Write(bytes.TrimSpace(source[:starts[0]]))
+	for _, fragment := range sorted {
+		sortedbuf.WriteString("\n\n")
+		sortedbuf.WriteString(fragment.source)
+	}
+	sortedbuf.WriteString("\n")
+	fixedsource, err := goimports.Process("", sortedbuf.Bytes(), nil)
+	if err != nil {
+		return newSourceError(sortedbuf, err)
+	}
+	path := p.Filepath(file)
+	sortedfile, err := p.Config.ParseFile(path, string(fixedsource))
+	if err != nil {
+		return newSourceError(bytes.NewBuffer(fixedsource), err)
+	}
+	p.AddFile(sortedfile)
+	return nil
+}
@@ -0,0 +1,82 @@
+package pkg
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// sortedNames sorts the fragments of the generated source in the file and returns
+// the names of the fragments in the order in which they appear in the result.
+// The file is reported as replaced when sorting changed it.
+func sortedNames(t *testing.T, source string) (names []string, replaced bool) {
+	t.Helper()
+	dir := writeModule(t, map[string]string{"generated.go": source})
+	p := modulePackage(t, dir, nil)
+	path := filepath.Join(dir, "generated.go")
+	file := p.fileset[path]
+	if err := p.sortFragments(file); err != nil {
+		t.Fatal(err)
+	}
+	sorted := fileSource(t, p, path)
+	if !strings.HasPrefix(sorted, generatedHeader) {
+		t.Errorf("header lost, got\n%s", sorted)
+	}
+	for _, match := range regexp.MustCompile(`(?m)^//jig:name (.*)$`).FindAllStringSubmatch(sorted, -1) {
+		names = append(names, match[1])
+	}
+	return names, p.fileset[path] != file
+}
+
+func TestSortFragments(t *testing.T) {
+	tests := []struct {
+		name      string
+		fragments string
+		sorted    []string
+		replaced  bool
+	}{{
+		name:      "single fragment",
+		fragments: "//jig:name b\n\nfunc b() {}\n",
+		sorted:    []string{"b"},
+	}, {
+		name:      "already sorted",
+		fragments: "//jig:name T\n\ntype T int\n\n//jig:name a\n\nfunc a() {}\n\n//jig:name T_M\n\nfunc (T) M() {}\n",
+		sorted:    []string{"T", "a", "T_M"},
+	}, {
+		name:      "types, then functions, then methods",
+		fragments: "//jig:name T_M\n\nfunc (T) M() {}\n\n//jig:name a\n\nfunc a() {}\n\n//jig:name T\n\ntype T int\n",
+		sorted:    []string{"T", "a", "T_M"},
+		replaced:  true,
+	}, {
+		name:      "same kind sorted by name",
+		fragments: "//jig:name c\n\nvar c = 1\n\n//jig:name b\n\nconst b = 2\n\n//jig:name a\n\nfunc a() {}\n",
+		sorted:    []string{"a", "b", "c"},
+		replaced:  true,
+	}, {
+		name:      "fragment declaring a type and methods is a type",
+		fragments: "//jig:name a\n\nfunc a() {}\n\n//jig:name U\n\nfunc (U) M() {}\n\ntype U int\n",
+		sorted:    []string{"U", "a"},
+		replaced:  true,
+	}, {
+		name:      "fragments with imports",
+		fragments: "//jig:name b\n\nfunc b() { fmt.Println() }\n\n//jig:name a\n\nfunc a() { fmt.Println() }\n",
+		sorted:    []string{"a", "b"},
+		replaced:  true,
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source := generatedHeader + "package lib\n\n"
+			if strings.Contains(test.fragments, "fmt.") {
+				source += "import \"fmt\"\n\n"
+			}
+			names, replaced := sortedNames(t, source+test.fragments)
+			if strings.Join(names, ",") != strings.Join(test.sorted, ",") {
+				t.Errorf("fragments sorted as %v; expected %v", names, test.sorted)
+			}
+			if replaced != test.replaced {
+				t.Errorf("file replaced is %v; expected %v", replaced, test.replaced)
+			}
+		})
+	}
+}
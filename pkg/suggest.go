@@ -1,6 +1,8 @@
 package pkg
 
 import (
+	"go/token"
+	"go/types"
 	"regexp"
 	"strings"
 )
@@ -9,23 +11,36 @@ import (
 // e.g. if the error mentions and ObservableInt that is missing a field or method MapFloat32 then the specialization
 // signature will become "ObservableInt MapFloat32" this signature can then be used by specialization code to generate
 // the required type, field or method definition.
-func (Package) SuggestTypesToGenerate(errs []error) []string {
+// When the error is about a type not implementing an interface, the method set of the interface is compared to that
+// of the type and a signature is suggested for every method that is missing, not just the one mentioned in the error.
+func (p *Package) SuggestTypesToGenerate(errs []error) []string {
 	if len(errs) == 0 {
 		return nil
 	}
 
 	var sigs []string
 	sigmap := make(map[string]struct{})
+	suggest := func(signature string, err error) {
+		signature = p.unqualify(signature)
+		if _, present := sigmap[signature]; !present {
+			sigmap[signature] = struct{}{}
+			sigs = append(sigs, signature)
+			pos, msg := p.errorPosition(err)
+			p.emitPosition(Event{Event: SignatureSuggested, Signature: signature, Message: msg}, pos)
+		}
+	}
 	for _, err := range errs {
+		if signatures := p.suggestMissingMethods(err); len(signatures) > 0 {
+			for _, signature := range signatures {
+				suggest(signature, err)
+			}
+			continue
+		}
 		errstr := err.Error()
 		for _, exp := range reFixableErrors {
 			matches := exp.FindStringSubmatch(errstr)
 			if len(matches) == 5 || len(matches) == 6 {
-				signature := strings.Join(matches[4:], " ")
-				if _, present := sigmap[signature]; !present {
-					sigmap[signature] = struct{}{}
-					sigs = append(sigs, signature)
-				}
+				suggest(strings.Join(matches[4:], " "), err)
 				break
 			}
 		}
@@ -33,6 +48,80 @@ func (Package) SuggestTypesToGenerate(errs []error) []string {
 	return sigs
 }
 
+// suggestMissingMethods will suggest a signature for every method of an interface
+// that is missing from the type that is being used as that interface. Returns nil
+// when the error is not about a missing method or when the types involved cannot
+// be resolved.
+func (p *Package) suggestMissingMethods(err error) []string {
+	terr, ok := err.(types.Error)
+	if !ok || p.info == nil {
+		return nil
+	}
+	matches := reMissingMethod.FindStringSubmatch(terr.Msg)
+	if len(matches) != 3 {
+		return nil
+	}
+
+	// Evaluate the names of the type and the interface at the position of the error,
+	// so qualified interface names like e.g. "io.Writer" can be resolved too.
+	typ := p.evalType(terr.Pos, matches[1])
+	iface := p.evalType(terr.Pos, matches[2])
+	if typ == nil || iface == nil {
+		return nil
+	}
+	named, ok := typ.(*types.Named)
+	if !ok {
+		return nil
+	}
+	itype, ok := iface.Underlying().(*types.Interface)
+	if !ok {
+		return nil
+	}
+
+	// Methods may be generated with a pointer receiver, so consider both.
+	mset := types.NewMethodSet(types.NewPointer(named))
+	var sigs []string
+	for i := 0; i < itype.NumMethods(); i++ {
+		method := itype.Method(i)
+		if mset.Lookup(method.Pkg(), method.Name()) == nil {
+			sigs = append(sigs, named.Obj().Name()+" "+method.Name())
+		}
+	}
+	return sigs
+}
+
+// evalType returns the type with the given name at the position of an error, or nil
+// when it cannot be resolved. The type checker qualifies names with the import path of
+// their package when package names are ambiguous, e.g. `"github.com/reactivego/rx".Observer`,
+// those are looked up in the imports of the package as types.Eval only resolves names
+// qualified by a package name.
+func (p *Package) evalType(pos token.Pos, name string) types.Type {
+	if i := strings.LastIndex(name, "."); i > 0 && strings.Contains(name[:i], "/") {
+		path, name := strings.Trim(name[:i], `"`), name[i+1:]
+		scope := p.info.Pkg.Scope()
+		if path != p.info.Pkg.Path() {
+			scope = nil
+			for _, imp := range p.info.Pkg.Imports() {
+				if imp.Path() == path {
+					scope = imp.Scope()
+				}
+			}
+		}
+		if scope == nil {
+			return nil
+		}
+		if obj, ok := scope.Lookup(name).(*types.TypeName); ok {
+			return obj.Type()
+		}
+		return nil
+	}
+	tv, err := types.Eval(p.Fset, p.info.Pkg, pos, name)
+	if err != nil {
+		return nil
+	}
+	return tv.Type
+}
+
 var reFixableErrors = []*regexp.Regexp{
 	regexp.MustCompile("^(.*):([0-9]*):([0-9]*): undeclared name: (.*)$"),
 	regexp.MustCompile("^(.*):([0-9]*):([0-9]*): invalid operation: .* [(]value of type [*](.*)[)] has no field or method (.*)"),
@@ -45,4 +134,13 @@ var reFixableErrors = []*regexp.Regexp{
 	regexp.MustCompile("^(.*):([0-9]*):([0-9]*): .* undefined [(]type (.*) has no field or method (.*)[)]"),
 	regexp.MustCompile("^(.*):([0-9]*):([0-9]*): .* [(]variable of type [*](.*)[)] .*: missing method (.*)"),
 	regexp.MustCompile("^(.*):([0-9]*):([0-9]*): .* [(]variable of type (.*)[)] .*: missing method (.*)"),
+
+	// Errors are reported differently for Go 1.20
+	regexp.MustCompile("^(.*):([0-9]*):([0-9]*): undefined: (.*)$"),
+	regexp.MustCompile("^(.*):([0-9]*):([0-9]*): .*: [*]?(.*) does not implement .* [(]missing method (.*)[)]"),
 }
+
+// reMissingMethod matches the message of an error about a type that does not
+// implement an interface. Matches will contain the type and the interface
+// e.g. ["StringStack does not implement Pusher (missing method Pop)", "StringStack", "Pusher"]
+var reMissingMethod = regexp.MustCompile(`[*]?([[:word:]"./-]+) does not implement ([^ ]+) [(]missing method [[:word:]]+[)]`)
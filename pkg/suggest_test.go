@@ -0,0 +1,57 @@
+package pkg
+
+import (
+	"strings"
+	"testing"
+)
+
+// suggestedSignatures writes the source to a new package dir, type checks it and
+// returns the signatures suggested by the errors that were found.
+func suggestedSignatures(t *testing.T, source string) []string {
+	t.Helper()
+	p := modulePackage(t, writeModule(t, map[string]string{"use.go": source}), nil)
+	return p.SuggestTypesToGenerate(checkPackage(t, p))
+}
+
+func TestSuggestTypesToGenerate(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		sigs   []string
+	}{{
+		name:   "undefined type",
+		source: "var s StringStack\n",
+		sigs:   []string{"StringStack"},
+	}, {
+		name:   "undefined method",
+		source: "type IntStack []int\n\nfunc use(s IntStack) { s.Push(1) }\n",
+		sigs:   []string{"IntStack Push"},
+	}, {
+		name: "all methods missing for an interface assertion",
+		source: `type Stacker interface {
+	Push(v int)
+	Pop() int
+	Top() int
+}
+
+type IntStack []int
+
+func (s *IntStack) Top() int { return (*s)[len(*s)-1] }
+
+var _ Stacker = (*IntStack)(nil)
+`,
+		sigs: []string{"IntStack Pop", "IntStack Push"},
+	}, {
+		name:   "methods missing for an interface of an imported package",
+		source: "import \"io\"\n\ntype IntStack []int\n\nvar _ io.ReadWriter = IntStack{}\n",
+		sigs:   []string{"IntStack Read", "IntStack Write"},
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sigs := suggestedSignatures(t, "package lib\n\n"+test.source)
+			if strings.Join(sigs, ",") != strings.Join(test.sigs, ",") {
+				t.Errorf("suggested %q; expected %q", sigs, test.sigs)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package pkg
+
+import (
+	"go/build"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// WatchDirs returns the dirs containing the source the generated code depends on: the
+// package dir, the output dir and the dirs of the imported packages outside of GOROOT,
+// e.g. those of the template libraries. The package must have been checked first.
+func (p *Package) WatchDirs() []string {
+	dirs := map[string]struct{}{filepath.Clean(p.Dir): {}}
+	if p.OutputDir != "" {
+		dirs[filepath.Clean(p.OutputDir)] = struct{}{}
+	}
+	for _, pkgInfo := range p.allPackages {
+		if pkgInfo == p.info || p.isGoroot(pkgInfo.Pkg.Path()) {
+			continue
+		}
+		for _, file := range pkgInfo.Files {
+			dirs[filepath.Dir(p.Fset.File(file.Pos()).Name())] = struct{}{}
+		}
+	}
+	var sorted []string
+	for dir := range dirs {
+		sorted = append(sorted, dir)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// TemplateDirs returns the dirs the templates available to the package are loaded from:
+// the dirs of the imported packages outside of GOROOT and, when it declares templates
+// itself, the package dir. The package must have been checked first.
+func (p *Package) TemplateDirs() []string {
+	dirs := make(map[string]struct{})
+	for _, pkgInfo := range p.allPackages {
+		if pkgInfo == p.info {
+			if p.declaresTemplates() {
+				dirs[filepath.Clean(p.Dir)] = struct{}{}
+			}
+			continue
+		}
+		if p.isGoroot(pkgInfo.Pkg.Path()) || pkgInfo.Pkg.Path() == p.outputPath {
+			continue
+		}
+		for _, file := range pkgInfo.Files {
+			dirs[filepath.Dir(p.Fset.File(file.Pos()).Name())] = struct{}{}
+		}
+	}
+	var sorted []string
+	for dir := range dirs {
+		sorted = append(sorted, dir)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// declaresTemplates returns true when a file of the package itself contains a
+// jig:template or jig:test pragma.
+func (p *Package) declaresTemplates() bool {
+	for _, file := range p.info.Files {
+		for _, cgroup := range file.Comments {
+			for _, comment := range cgroup.List {
+				if strings.HasPrefix(comment.Text, jigTemplate) || strings.HasPrefix(comment.Text, jigTest) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// ReuseImports makes the package use the packages found for imports by the previous
+// package, so they don't have to be looked up again. Packages in the changed dirs are
+// looked up again, because files may have been added to or removed from them.
+func (p *Package) ReuseImports(previous *Package, changed []string) {
+	dirs := make(map[string]struct{})
+	for _, dir := range changed {
+		dirs[filepath.Clean(dir)] = struct{}{}
+	}
+	previous.cache.Range(func(path, bpkg interface{}) bool {
+		if _, present := dirs[filepath.Clean(bpkg.(*build.Package).Dir)]; !present {
+			p.cache.Store(path, bpkg)
+		}
+		return true
+	})
+}
@@ -0,0 +1,40 @@
+package pkg
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTemplateDirs(t *testing.T) {
+	tests := []struct {
+		name string
+		app  string
+		dirs []string
+	}{{
+		name: "library",
+		app:  "package app\n\nimport (\n\t\"fmt\"\n\n\t_ \"example.com/test/lib\"\n)\n\nvar _ = fmt.Sprint\n",
+		dirs: []string{"lib"},
+	}, {
+		name: "library and own templates",
+		app:  "package app\n\nimport _ \"example.com/test/lib\"\n\n//jig:template <Foo>Box\n\ntype FooBox struct{ v foo }\n\ntype foo int\n",
+		dirs: []string{"app", "lib"},
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root := writeModule(t, map[string]string{
+				"lib/lib.go": stackLibrary("lib", ""),
+				"app/app.go": test.app,
+			})
+			p := modulePackage(t, filepath.Join(root, "app"), nil)
+			checkPackage(t, p)
+			var expected []string
+			for _, dir := range test.dirs {
+				expected = append(expected, filepath.Join(root, dir))
+			}
+			if dirs := p.TemplateDirs(); strings.Join(dirs, ",") != strings.Join(expected, ",") {
+				t.Errorf("got template dirs %q; expected %q", dirs, expected)
+			}
+		})
+	}
+}
@@ -1,42 +1,259 @@
 package pkg
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"go/ast"
 	"go/printer"
 	"io"
+	"io/ioutil"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
 )
 
 // WriteGeneratedSources is used to write the generated
-// sources to file(s).
+// sources to file(s). Fragments are sorted before writing.
+// Files of the package rewritten to refer to source
+// generated into the output dir are written too.
 func (p *Package) WriteGeneratedSources() ([]string, error) {
-	return p.WriteFileset(p.GeneratedFileset())
+	if err := p.SortGeneratedSources(); err != nil {
+		return nil, err
+	}
+	fileset := p.GeneratedFileset()
+	for path := range p.rewritten {
+		fileset[p.fileset[path]] = struct{}{}
+	}
+	return p.WriteFileset(fileset)
 }
 
+// errInterrupted is returned when writing files was interrupted before
+// any of the files in the package dir were replaced.
+var errInterrupted = errors.New("interrupted, no files were written")
+
 // WriteFileset writes the set of files that contains generated source to disk.
+// Nothing is written when the package is doing a dry run.
+//
+// Files are first written to temporary files in the package dir. Only when all
+// of them have been written successfully are they renamed to their final path
+// and are files removed that no longer contain generated source. If anything
+// fails or the process is interrupted before that, the temporary files are
+// removed and the files in the package dir are left untouched. The files that
+// are replaced or removed are backed up until all files are in place, so they
+// can be restored when replacing or removing one of the files fails. An interrupt
+// received while the files are put in place is raised again afterwards.
 func (p *Package) WriteFileset(fileset map[*ast.File]struct{}) (messages []string, err error) {
+	if p.DryRun {
+		return nil, nil
+	}
+
+	// Delay interrupts until the files are either in place or rolled back.
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer func() {
+		signal.Stop(interrupt)
+		select {
+		case sig := <-interrupt:
+			if proc, err := os.FindProcess(os.Getpid()); err == nil {
+				proc.Signal(sig)
+			}
+		default:
+		}
+	}()
+
+	// Stage all files.
+	staged := make(map[string]string)
+	rollback := func() {
+		for _, tmp := range staged {
+			os.Remove(tmp)
+		}
+	}
 	for file := range fileset {
 		path := p.Filepath(file)
+		tmp, err := p.stageFile(path, file)
+		if err != nil {
+			rollback()
+			return nil, writeError(path, err)
+		}
+		staged[path] = tmp
+	}
+	select {
+	case <-interrupt:
+		rollback()
+		return nil, errInterrupted
+	default:
+	}
 
-		// create a file to write the new package content to.
-		f, err := os.Create(path)
+	// Commit all files, keeping a backup of every file replaced or removed.
+	var written, removed []string
+	for path := range staged {
+		written = append(written, path)
+	}
+	sort.Strings(written)
+	for path := range p.removed {
+		if _, present := p.fileset[path]; !present {
+			removed = append(removed, path)
+		}
+	}
+	sort.Strings(removed)
+	var backups []backup
+	created := make(map[string]bool)
+	restore := func() {
+		for i := len(backups) - 1; i >= 0; i-- {
+			backups[i].restore()
+		}
+	}
+	for _, path := range written {
+		b, err := backupFile(path, true)
+		if err == nil {
+			backups = append(backups, b)
+			created[path] = b.backup == ""
+			err = commitFile(staged[path], path)
+		}
 		if err != nil {
-			return messages, err
+			restore()
+			rollback()
+			return nil, writeError(path, err)
 		}
-		defer f.Close()
+		delete(staged, path)
+	}
+	for _, path := range removed {
+		b, err := backupFile(path, false)
+		if err != nil {
+			restore()
+			return nil, writeError(path, err)
+		}
+		backups = append(backups, b)
+	}
+
+	// All files are in place.
+	for _, b := range backups {
+		b.remove()
+	}
+	for _, path := range written {
+		messages = append(messages, p.report(Event{Event: FileWritten, File: path, Created: created[path]}))
+	}
+	for _, path := range removed {
+		messages = append(messages, p.report(Event{Event: FileRemoved, File: path}))
+		delete(p.removed, path)
+	}
+	return messages, nil
+}
+
+// commitFile puts a staged file in place. Tests replace it to make committing fail.
+var commitFile = os.Rename
+
+// backup is the backup of a file that is replaced or removed while writing files.
+type backup struct {
+	// path of the file.
+	path string
+	// backup is the path of the backup, empty when there was no file to back up.
+	backup string
+}
+
+// backupFile backs up the file at path in the same dir. When keep is set, the file is
+// left in place to be replaced, otherwise it is moved out of the way and so removed.
+func backupFile(path string, keep bool) (backup, error) {
+	b := backup{path: path}
+	if _, err := os.Lstat(path); os.IsNotExist(err) {
+		return b, nil
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".jig-backup-")
+	if err != nil {
+		return b, err
+	}
+	name := f.Name()
+	f.Close()
+	os.Remove(name)
+	if keep && os.Link(path, name) == nil {
+		b.backup = name
+		return b, nil
+	}
+	if err := os.Rename(path, name); err != nil {
+		return b, err
+	}
+	b.backup = name
+	return b, nil
+}
+
+// restore puts the file back the way it was before it was replaced or removed. A
+// backup that is a link to a file that was not replaced yet is left behind by rename,
+// so it is removed afterwards.
+func (b backup) restore() {
+	if b.backup == "" {
+		os.Remove(b.path)
+		return
+	}
+	os.Rename(b.backup, b.path)
+	os.Remove(b.backup)
+}
 
-		messages = append(messages, fmt.Sprintf("writing file %q", path))
+// remove removes the backup once it is no longer needed.
+func (b backup) remove() {
+	if b.backup != "" {
+		os.Remove(b.backup)
+	}
+}
 
-		// actually write the package content out to file.
-		if e := p.WriteFile(f, file); e != nil {
-			err = e
+// stageFile writes the file to a temporary file in the same directory as path and
+// returns the path of the temporary file. The temporary file name starts with a
+// dot so the go tool ignores it should it ever be left behind.
+func (p *Package) stageFile(path string, file *ast.File) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".jig-")
+	if err != nil {
+		return "", err
+	}
+	tmp := f.Name()
+	err = p.WriteFile(f, file)
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		if info, e := os.Stat(path); e == nil {
+			err = os.Chmod(tmp, info.Mode())
+		} else {
+			err = os.Chmod(tmp, 0644)
 		}
 	}
-	return messages, err
+	if err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return tmp, nil
 }
 
-// WriteFile will write the given file to the given output.
+// WriteFile will write the given file to the given output. The markers at the end of
+// declarations that //line directives attribute to templates are written as //line
+// directives pointing back into the file itself.
 func (p *Package) WriteFile(output io.Writer, file *ast.File) error {
-	return printer.Fprint(output, p.Fset, file)
+	var source bytes.Buffer
+	if err := printer.Fprint(&source, p.Fset, file); err != nil {
+		return err
+	}
+	_, err := output.Write(lineDirectivesBack(source.Bytes(), filepath.Base(p.Filepath(file))))
+	return err
+}
+
+// lineDirectivesBack replaces the line end markers in the source of the file with the
+// given name by //line directives for the line that follows, e.g. "//line rx.go:123".
+// The directives already pointing back into the file are updated, as lines may have
+// moved since they were written.
+func lineDirectivesBack(source []byte, name string) []byte {
+	if !bytes.Contains(source, []byte("//line ")) {
+		return source
+	}
+	back := regexp.MustCompile("^//line " + regexp.QuoteMeta(name) + ":[0-9]+\n$")
+	lines := bytes.SplitAfter(source, []byte("\n"))
+	for i, line := range lines {
+		if string(line) == lineEndMarker+"\n" || back.Match(line) {
+			lines[i] = []byte(fmt.Sprintf("//line %s:%d\n", name, i+2))
+		}
+	}
+	return bytes.Join(lines, nil)
 }
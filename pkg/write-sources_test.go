@@ -0,0 +1,149 @@
+package pkg
+
+import (
+	"errors"
+	"go/ast"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// changedPackage writes the files a.go, b.go and c.go with generated source to a new
+// package dir and then changes the source of a.go and b.go and removes c.go in memory.
+// The file b.go is changed to be in the dir at the given path relative to the package
+// dir. Returns the package dir and the fileset to write.
+func changedPackage(t *testing.T, bdir string) (*Package, map[*ast.File]struct{}) {
+	t.Helper()
+	files := make(map[string]string)
+	for _, name := range []string{"a", "b", "c"} {
+		files[name+".go"] = generatedHeader + "package lib\n\n//jig:name " + name + "\n\nvar " + name + " = \"old\"\n"
+	}
+	dir := writeModule(t, files)
+	p := modulePackage(t, dir, nil)
+	fileset := make(map[*ast.File]struct{})
+	for _, name := range []string{"a", "b"} {
+		path := filepath.Join(dir, name+".go")
+		if name == "b" {
+			path = filepath.Join(dir, bdir, name+".go")
+		}
+		file, err := p.Config.ParseFile(path, generatedHeader+"package lib\n\n//jig:name "+name+"\n\nvar "+name+" = \"new\"\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.AddFile(file)
+		fileset[file] = struct{}{}
+	}
+	path := filepath.Join(dir, "c.go")
+	delete(p.fileset, path)
+	p.removed[path] = struct{}{}
+	return p, fileset
+}
+
+// dirContents returns the names of the files in the dir with the value they declare,
+// leaving out the go.mod file written by writeModule.
+// e.g. "a.go=old"
+func dirContents(t *testing.T, dir string) string {
+	t.Helper()
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var contents []string
+	for _, info := range infos {
+		if info.IsDir() || info.Name() == "go.mod" {
+			continue
+		}
+		content, err := ioutil.ReadFile(filepath.Join(dir, info.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		value := "?"
+		for _, v := range []string{"old", "new"} {
+			if strings.Contains(string(content), `"`+v+`"`) {
+				value = v
+			}
+		}
+		contents = append(contents, info.Name()+"="+value)
+	}
+	sort.Strings(contents)
+	return strings.Join(contents, " ")
+}
+
+func TestWriteFileset(t *testing.T) {
+	p, fileset := changedPackage(t, "")
+	messages, err := p.WriteFileset(fileset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 3 {
+		t.Errorf("messages %q; expected 2 files written and 1 removed", messages)
+	}
+	if contents := dirContents(t, p.Dir); contents != "a.go=new b.go=new" {
+		t.Errorf("dir contains %s; expected a.go=new b.go=new", contents)
+	}
+}
+
+func TestWriteFilesetRollback(t *testing.T) {
+	t.Run("staging fails", func(t *testing.T) {
+		// The dir of b.go cannot be created, because a file is in the way.
+		p, fileset := changedPackage(t, "sub")
+		if err := ioutil.WriteFile(filepath.Join(p.Dir, "sub"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := p.WriteFileset(fileset)
+		var writeErr *WriteError
+		if !errors.As(err, &writeErr) || writeErr.Path != filepath.Join(p.Dir, "sub", "b.go") {
+			t.Fatalf("got error %v; expected a WriteError for sub/b.go", err)
+		}
+		if contents := dirContents(t, p.Dir); contents != "a.go=old b.go=old c.go=old sub=?" {
+			t.Errorf("dir contains %s; expected the files to be left untouched", contents)
+		}
+	})
+	t.Run("committing fails", func(t *testing.T) {
+		// Putting b.go in place fails after a.go has been replaced.
+		p, fileset := changedPackage(t, "")
+		defer func() { commitFile = os.Rename }()
+		commitFile = func(from, to string) error {
+			if filepath.Base(to) == "b.go" {
+				return errors.New("disk full")
+			}
+			return os.Rename(from, to)
+		}
+		_, err := p.WriteFileset(fileset)
+		var writeErr *WriteError
+		if !errors.As(err, &writeErr) || writeErr.Path != filepath.Join(p.Dir, "b.go") {
+			t.Fatalf("got error %v; expected a WriteError for b.go", err)
+		}
+		if contents := dirContents(t, p.Dir); contents != "a.go=old b.go=old c.go=old" {
+			t.Errorf("dir contains %s; expected the files to be restored", contents)
+		}
+	})
+}
+
+func TestWriteFilesetEvents(t *testing.T) {
+	p, fileset := changedPackage(t, "sub")
+	var events []Event
+	p.Events = func(event Event) { events = append(events, event) }
+	messages, err := p.WriteFileset(fileset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reported []string
+	for _, event := range events {
+		reported = append(reported, event.String())
+	}
+	expected := []string{
+		`writing file "` + filepath.Join(p.Dir, "a.go") + `"`,
+		`writing file "` + filepath.Join(p.Dir, "sub", "b.go") + `"`,
+		`removing file "` + filepath.Join(p.Dir, "c.go") + `"`,
+	}
+	if strings.Join(messages, "\n") != strings.Join(expected, "\n") || strings.Join(reported, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("got messages %q and events reported as %q; expected %q", messages, reported, expected)
+	}
+	if len(events) == 3 && (events[0].Created || !events[1].Created) {
+		t.Errorf("got events %+v; expected only sub/b.go to be created", events)
+	}
+}
@@ -0,0 +1,35 @@
+package templ
+
+import "fmt"
+
+// TemplateError is returned when a template is malformed, e.g. when its source is not a
+// valid go template, when it is defined twice in a package or when specializing it does
+// not result in valid source code. Other errors adding the specialized source to the
+// package, e.g. those writing the file, are not template errors.
+type TemplateError struct {
+	// Template is the name of the template.
+	// e.g. "Observable<Foo> Map<Bar>"
+	Template string
+	// PackagePath is the import path of the package in which the template was found.
+	// e.g. "github.com/reactivego/rx/generic"
+	PackagePath string
+	// Err is the error found in the template.
+	Err error
+}
+
+func (e *TemplateError) Error() string {
+	return fmt.Sprintf("template %q (%s): %v", e.Template, e.PackagePath, e.Err)
+}
+
+func (e *TemplateError) Unwrap() error {
+	return e.Err
+}
+
+// templateError returns err as a TemplateError for the generic t, unless it is nil or
+// already a TemplateError.
+func templateError(t *Generic, err error) error {
+	if _, ok := err.(*TemplateError); ok || err == nil {
+		return err
+	}
+	return &TemplateError{Template: t.Name, PackagePath: t.PackagePath, Err: err}
+}
@@ -0,0 +1,119 @@
+package templ
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Explain traces how the signature is resolved to a generic: the generics whose regular
+// expression matches the signature and the types they bind, why candidates are rejected,
+// which one is used and the dot map it is specialized with. The needs of the generic are
+// resolved the same way, as are the embedded types tried when no generic matches a method.
+// Finally the fragments that generating code for the signature would add are listed.
+// The signature may also be given as an identifier, see signatureOf.
+func (tpls *templatemanager) Explain(pkg PackageWriter, signature string) (messages []string, err error) {
+	identifier := signature
+	signature = tpls.signatureOf(identifier)
+	tpls.trace, tpls.depth = []string{}, 0
+	defer func() { tpls.trace = nil }()
+	if signature != identifier {
+		tpls.tracef("explaining %q as %q", identifier, signature)
+	}
+
+	var applies []*apply
+	missing, err := generateApplies(tpls, signature, pkg, func(a *apply) (bool, error) {
+		return tpls.SkipSpecialize(pkg, a)
+	}, func(a *apply) {
+		applies = append(applies, a)
+	})
+	messages = append(messages, tpls.trace...)
+	if err != nil {
+		return messages, err
+	}
+	if len(applies) == 0 {
+		messages = append(messages, fmt.Sprintf("nothing to generate for %q", signature))
+	} else {
+		messages = append(messages, fmt.Sprintf("generating %q would add", signature))
+	}
+	for _, a := range applies {
+		name, err := tpls.expand(a.nameID(), tpls.Dot(pkg.Typemap(), a.types))
+		if err != nil {
+			return messages, templateError(a.Generic, err)
+		}
+		if a.unexported {
+			name = unexport(name)
+		}
+		messages = append(messages, fmt.Sprintf("  %q from template %q (%s) <%s>", name, a.Name, a.PackagePath, strings.Join(a.types, ">,<")))
+	}
+	for _, msg := range missing {
+		messages = append(messages, "  "+msg)
+	}
+	return messages, nil
+}
+
+// signatureOf returns the signature for an identifier. An identifier containing spaces
+// already is a signature and underscores separate the type from the method like in the
+// names of fragments. Otherwise the identifier is split in front of an upper case letter
+// into a type and a method. The identifier itself and every split are matched against the
+// sorted generics, the one matching the first generic is used.
+// e.g. "ObservableIntMapString" -> "ObservableInt MapString"
+func (tpls *templatemanager) signatureOf(identifier string) string {
+	if strings.ContainsAny(identifier, " _") {
+		return strings.Join(strings.FieldsFunc(identifier, func(r rune) bool { return r == ' ' || r == '_' }), " ")
+	}
+	candidates := []string{identifier}
+	for i, r := range identifier {
+		if i > 0 && unicode.IsUpper(r) {
+			candidates = append(candidates, identifier[:i]+" "+identifier[i:])
+		}
+	}
+	signature, first := identifier, len(tpls.Generics)
+	for _, candidate := range candidates {
+		for i, t := range tpls.Generics[:first] {
+			if t.signature != nil && t.signature.MatchString(export(candidate)) {
+				signature, first = candidate, i
+				break
+			}
+		}
+	}
+	return signature
+}
+
+// tracef adds a line to the trace when explaining how a signature is resolved.
+func (tpls *templatemanager) tracef(format string, args ...interface{}) {
+	if tpls.trace != nil {
+		tpls.trace = append(tpls.trace, strings.Repeat("  ", tpls.depth)+fmt.Sprintf(format, args...))
+	}
+}
+
+// formatBindings formats the types bound to the template vars.
+// e.g. "Foo=Int Bar=String"
+func formatBindings(vars, types []string) string {
+	var bindings []string
+	for i, typ := range types {
+		if i < len(vars) {
+			bindings = append(bindings, fmt.Sprintf("%s=%s", vars[i], typ))
+		}
+	}
+	if len(bindings) == 0 {
+		return "nothing"
+	}
+	return strings.Join(bindings, " ")
+}
+
+// formatDot formats the dot map sorted by key.
+// e.g. "T=Int U=String t=int u=string"
+func formatDot(dot map[string]string) string {
+	var keys []string
+	for key := range dot {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var entries []string
+	for _, key := range keys {
+		entries = append(entries, fmt.Sprintf("%s=%s", key, dot[key]))
+	}
+	return strings.Join(entries, " ")
+}
@@ -0,0 +1,272 @@
+package templ
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Kinds of edges in a Graph.
+const (
+	// NeedsEdge is the kind of edge for a generic needed through jig:needs.
+	NeedsEdge = "needs"
+	// EmbedsEdge is the kind of edge for a type embedded through jig:embeds.
+	EmbedsEdge = "embeds"
+	// CommonEdge is the kind of edge for the support code of a jig:common generic.
+	CommonEdge = "common"
+)
+
+// Graph is the dependency graph of generics through jig:needs, jig:embeds and jig:common.
+// The nodes are either the generics themselves or, when the graph is instantiated for a
+// signature, the signatures that the generics are specialized for.
+type Graph struct {
+	Nodes []*Node `json:"nodes"`
+	Edges []*Edge `json:"edges"`
+
+	// index maps the ID of a node to the node.
+	index map[string]*Node
+}
+
+// Node is a generic, or a signature it is specialized for, in a Graph.
+type Node struct {
+	// ID uniquely identifies the node in the graph. It is the name of the generic, followed
+	// by its package path when libraries define generics with the same name, or the signature.
+	// e.g. "Observable<Foo> Map<Bar>" or "ObservableInt MapString"
+	ID string `json:"id"`
+	// Template is the name of the generic.
+	// e.g. "Observable<Foo> Map<Bar>"
+	Template string `json:"template,omitempty"`
+	// PackagePath is the import path of the package in which the generic was found.
+	// e.g. "github.com/reactivego/rx/generic"
+	PackagePath string `json:"package,omitempty"`
+	// Types contains the types bound to the template vars of the generic.
+	// e.g. ["Int", "String"]
+	Types []string `json:"types,omitempty"`
+	// Fragment is the name of the source fragment specializing the generic for the types.
+	// e.g. "ObservableInt_MapString"
+	Fragment string `json:"fragment,omitempty"`
+	// Common is set when the generic is marked with jig:common.
+	Common bool `json:"common,omitempty"`
+	// Missing is set when no generic was found for the node.
+	Missing bool `json:"missing,omitempty"`
+}
+
+// Edge is a dependency of one node on another in a Graph.
+type Edge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Kind is either NeedsEdge, EmbedsEdge or CommonEdge.
+	Kind string `json:"kind"`
+}
+
+// Graph returns the dependency graph of the generics. When signature is not empty, the
+// graph is instantiated for the signature, following the needs and embeds of the generic
+// it is resolved to. Ambiguous and missing signatures are returned as messages.
+func (tpls *templatemanager) Graph(pkg PackageWriter, signature string) (graph *Graph, messages []string, err error) {
+	tpls.graph = &Graph{Nodes: []*Node{}, Edges: []*Edge{}}
+	defer func() { tpls.graph = nil }()
+	if signature == "" {
+		return tpls.genericGraph(), nil, nil
+	}
+	messages, err = generateApplies(tpls, signature, pkg, func(a *apply) (bool, error) {
+		return false, nil
+	}, func(a *apply) {})
+	return tpls.graph, messages, err
+}
+
+// genericGraph returns the graph of the generics themselves, sorted by import path and
+// name. A need is resolved to the generic with the same name, ignoring the names of the
+// template vars, or else to the generic it matches. Generics in the package of the
+// generic that needs them are preferred.
+func (tpls *templatemanager) genericGraph() *Graph {
+	generics := append([]*Generic(nil), tpls.Generics...)
+	sort.SliceStable(generics, func(i, j int) bool {
+		if generics[i].PackagePath != generics[j].PackagePath {
+			return generics[i].PackagePath < generics[j].PackagePath
+		}
+		return generics[i].Name < generics[j].Name
+	})
+	packages := make(map[string]int)
+	for _, t := range generics {
+		packages[t.Name]++
+	}
+	id := func(t *Generic) string {
+		if packages[t.Name] > 1 {
+			return fmt.Sprintf("%s (%s)", t.Name, t.PackagePath)
+		}
+		return t.Name
+	}
+	for _, t := range generics {
+		tpls.graph.add(&Node{ID: id(t), Template: t.Name, PackagePath: t.PackagePath, Common: t.Common})
+	}
+	for _, t := range generics {
+		for _, need := range t.Needs {
+			if n := tpls.resolveNeed(t, need); n != nil {
+				tpls.graphEdge(id(t), id(n), tpls.needKind(t, need))
+			} else {
+				tpls.graphEdge(id(t), need, tpls.needKind(t, need))
+				tpls.graphMissing(need, true)
+			}
+		}
+	}
+	return tpls.graph
+}
+
+// resolveNeed returns the generic the need of generic t refers to, or nil when there is none.
+// e.g. the need "Observable<Bar>" refers to the generic "Observable<Foo>" and the need
+// "ObservableInt" is matched by it.
+func (tpls *templatemanager) resolveNeed(t *Generic, need string) *Generic {
+	var found []*Generic
+	key := reTemplateVar.ReplaceAllString(need, "<>")
+	for _, n := range tpls.Generics {
+		if reTemplateVar.ReplaceAllString(n.Name, "<>") == key {
+			found = append(found, n)
+		}
+	}
+	if len(found) == 0 {
+		// e.g. "Observable<Foo> MapInt" -> "ObservableFoo MapInt"
+		signature := reTemplateVar.ReplaceAllStringFunc(need, func(v string) string {
+			return strings.Trim(v, "<>")
+		})
+		for _, n := range tpls.Generics {
+			if n.signature.MatchString(signature) && (len(found) == 0 || n.Name == found[0].Name) {
+				found = append(found, n)
+			}
+		}
+	}
+	for _, n := range found {
+		if n.PackagePath == t.PackagePath {
+			return n
+		}
+	}
+	if len(found) > 0 {
+		return found[0]
+	}
+	return nil
+}
+
+// needKind returns the kind of edge for the need of generic t. It returns an empty
+// string when not building a graph.
+func (tpls *templatemanager) needKind(t *Generic, need string) string {
+	if tpls.graph == nil {
+		return ""
+	}
+	for _, embed := range t.Embeds {
+		if embed == need {
+			return EmbedsEdge
+		}
+	}
+	for _, n := range tpls.Generics {
+		if n.Common && n.Name == need && n.PackagePath == t.PackagePath {
+			return CommonEdge
+		}
+	}
+	return NeedsEdge
+}
+
+// graphNode adds a node for the signature resolved to the generic of the apply.
+func (tpls *templatemanager) graphNode(signature string, appl *apply, pkg PackageWriter) error {
+	if tpls.graph == nil {
+		return nil
+	}
+	name, err := tpls.expand(appl.nameID(), tpls.Dot(pkg.Typemap(), appl.types))
+	if err != nil {
+		return templateError(appl.Generic, err)
+	}
+	if appl.unexported {
+		name = unexport(name)
+	}
+	tpls.graph.add(&Node{ID: signature, Template: appl.Name, PackagePath: appl.PackagePath, Types: appl.types, Fragment: name, Common: appl.Common})
+	return nil
+}
+
+// graphMissing adds a node for a signature that does not resolve to a generic. It is
+// not missing when the methods of the types embedded by its type may implement it.
+func (tpls *templatemanager) graphMissing(signature string, missing bool) {
+	if tpls.graph != nil {
+		tpls.graph.add(&Node{ID: signature, Missing: missing})
+	}
+}
+
+// graphEdge adds an edge of the given kind between two nodes, unless the nodes are
+// already connected e.g. by an explicit need for a jig:common generic.
+func (tpls *templatemanager) graphEdge(from, to, kind string) {
+	if tpls.graph == nil {
+		return
+	}
+	for _, edge := range tpls.graph.Edges {
+		if edge.From == from && edge.To == to {
+			return
+		}
+	}
+	tpls.graph.Edges = append(tpls.graph.Edges, &Edge{From: from, To: to, Kind: kind})
+}
+
+// add adds the node to the graph, unless a node with the same ID is already present.
+func (g *Graph) add(node *Node) {
+	if g.index == nil {
+		g.index = make(map[string]*Node)
+	}
+	if _, present := g.index[node.ID]; !present {
+		g.index[node.ID] = node
+		g.Nodes = append(g.Nodes, node)
+	}
+}
+
+// WriteJSON writes the graph as a JSON object with "nodes" and "edges" arrays.
+func (g *Graph) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(g)
+}
+
+// WriteDOT writes the graph in the Graphviz DOT language. Nodes are labeled with the
+// template and the package it was found in. Embeds are drawn as dashed edges, common
+// support code as dotted edges and missing nodes in red. A signature implemented by the
+// methods of an embedded type is drawn dashed.
+// e.g. jig graph | dot -Tsvg > graph.svg
+func (g *Graph) WriteDOT(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("digraph jig {\n\tnode [shape=box];\n")
+	for _, node := range g.Nodes {
+		var attrs []string
+		switch {
+		case node.Missing:
+			attrs = append(attrs, "color=red", "fontcolor=red")
+		case node.Template == "":
+			attrs = append(attrs, "style=dashed")
+		case node.ID != node.Template:
+			attrs = append(attrs, "label="+dotID(node.ID+"\n"+node.Template+"\n"+node.PackagePath))
+		default:
+			attrs = append(attrs, "label="+dotID(node.Template+"\n"+node.PackagePath))
+		}
+		if node.Common {
+			attrs = append(attrs, "style=rounded")
+		}
+		fmt.Fprintf(&b, "\t%s [%s];\n", dotID(node.ID), strings.Join(attrs, ", "))
+	}
+	for _, edge := range g.Edges {
+		switch edge.Kind {
+		case EmbedsEdge:
+			fmt.Fprintf(&b, "\t%s -> %s [style=dashed, label=%s];\n", dotID(edge.From), dotID(edge.To), dotID(edge.Kind))
+		case CommonEdge:
+			fmt.Fprintf(&b, "\t%s -> %s [style=dotted];\n", dotID(edge.From), dotID(edge.To))
+		default:
+			fmt.Fprintf(&b, "\t%s -> %s;\n", dotID(edge.From), dotID(edge.To))
+		}
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// dotID returns the string quoted as a DOT identifier, with newlines as line breaks.
+func dotID(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	return `"` + s + `"`
+}
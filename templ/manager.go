@@ -2,12 +2,17 @@ package templ
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
 	"regexp"
 	"sort"
 	"strings"
 	"text/template"
 	"unicode"
+	"unicode/utf8"
 )
 
 // templatemanager manages all information about defined templates and
@@ -19,6 +24,18 @@ type templatemanager struct {
 
 	// GoTemplates is the repository for defined go templates added via Specializer.Parse()
 	GoTemplates *template.Template
+
+	// Tests maps the test ID of a generic to the generic of its tests.
+	Tests map[string]*Generic
+
+	// trace collects the lines explaining how a signature is resolved, it is nil
+	// when not explaining. Lines are indented by depth.
+	trace []string
+	depth int
+
+	// graph collects the nodes and edges visited while resolving a signature, it is
+	// nil when not building a graph.
+	graph *Graph
 }
 
 func NewSpecializer() Specializer {
@@ -46,7 +63,12 @@ func (tpls *templatemanager) Sort() {
 //    the go template.
 var stdVar = []string{"T", "U", "V", "W", "X", "Y", "Z"}
 
+// Add adds the generic with its source. Errors in the generic are returned as TemplateError.
 func (tpls *templatemanager) Add(t Generic, source string) error {
+	return templateError(&t, tpls.add(t, source))
+}
+
+func (tpls *templatemanager) add(t Generic, source string) error {
 
 	// identifier is Name with all space characters and angle brackets removed
 	// e.g. Observable<Int> Map<Bar> becomes ObservableIntMapBar
@@ -61,6 +83,21 @@ func (tpls *templatemanager) Add(t Generic, source string) error {
 		t.identifier = strings.Replace(t.identifier, fmt.Sprintf("<%s>", varname), varname, -1)
 	}
 
+	// The source of a test is only specialized along with the generic it tests.
+	if t.Test {
+		if _, present := tpls.Tests[t.testID()]; present {
+			return errors.New("duplicate test")
+		}
+		if _, err := tpls.GoTemplates.Parse(fmt.Sprintf("{{define %q}}%s{{end}}\n", t.testID(), sourceTemplate(t, source))); err != nil {
+			return err
+		}
+		if tpls.Tests == nil {
+			tpls.Tests = make(map[string]*Generic)
+		}
+		tpls.Tests[t.testID()] = &t
+		return nil
+	}
+
 	// Name template used for generated source fragment names as value in //jig:name pragma and for use in source file name.
 	nametpl := t.identifier
 	// e.g. "ObservableFoo_MapBar" -> "Observable{{.T}}_Map{{.U}}"
@@ -73,36 +110,53 @@ func (tpls *templatemanager) Add(t Generic, source string) error {
 	}
 
 	// Source template for generating the actual fragment source code.
-	// e.g. blahFooblahfooblah blahBarblahbarblah -> blah{{.T}}blah{{.t}}blah blah{{.U}}blah{{.u}}blah
-	sourcetpl := source
-	for i, varname := range t.Vars {
-		sourcetpl = strings.Replace(sourcetpl, varname, fmt.Sprintf("{{.%s}}", stdVar[i]), -1)
-		sourcetpl = strings.Replace(sourcetpl, strings.ToLower(varname), fmt.Sprintf("{{.%s}}", strings.ToLower(stdVar[i])), -1)
-	}
-	_, err = tpls.GoTemplates.Parse(fmt.Sprintf("{{define %q}}%s{{end}}\n", t.sourceID(), sourcetpl))
+	_, err = tpls.GoTemplates.Parse(fmt.Sprintf("{{define %q}}%s{{end}}\n", t.sourceID(), sourceTemplate(t, source)))
 	if err != nil {
 		return err
 	}
 
 	// Convert e.g. "Observable<Foo>" into regular expression "^Observable([[:word:]]+)$"
 	// Then compile this and assign to t.signature used for matching to missing type signatures.
+	// The adjacent template vars of a generic converted from type parameters e.g.
+	// "Map<JigA><JigB>" are told apart by the upper case first letter of the types
+	// e.g. "MapIntString" -> ["Int", "String"].
 	sig := t.Name
 	for _, tplvar := range t.Vars {
-		sig = strings.Replace(sig, fmt.Sprintf("<%s>", tplvar), "([[:word:]]*)", -1)
+		placeholder := fmt.Sprintf("<%s>", tplvar)
+		expr := "([[:word:]]*)"
+		if i := strings.Index(sig, placeholder); i >= 0 && t.TypeParams {
+			if strings.HasPrefix(sig[i+len(placeholder):], "<") {
+				expr = "([[:upper:]][[:word:]]*?)"
+			} else if strings.HasSuffix(sig[:i], "?)") {
+				expr = "([[:upper:]][[:word:]]*)"
+			}
+		}
+		sig = strings.Replace(sig, placeholder, expr, -1)
 	}
 	sig = fmt.Sprintf("^%s$", sig)
 	t.signature = regexp.MustCompile(sig)
 
-	// No duplicate templates allowed, return error if one is found.
+	// No duplicate templates allowed within a package, return error if one is found.
+	// Templates with the same name from different packages are resolved by find.
 	for _, tpl := range tpls.Generics {
-		if tpl.Name == t.Name {
-			return fmt.Errorf("duplicate template %q", t.Name)
+		if tpl.Name == t.Name && tpl.PackagePath == t.PackagePath {
+			return errors.New("duplicate template")
 		}
 	}
 	tpls.Generics = append(tpls.Generics, &t)
 	return nil
 }
 
+// sourceTemplate returns the go template for the source of the generic.
+// e.g. blahFooblahfooblah blahBarblahbarblah -> blah{{.T}}blah{{.t}}blah blah{{.U}}blah{{.u}}blah
+func sourceTemplate(t Generic, source string) string {
+	for i, varname := range t.Vars {
+		source = strings.Replace(source, varname, fmt.Sprintf("{{.%s}}", stdVar[i]), -1)
+		source = strings.Replace(source, strings.ToLower(varname), fmt.Sprintf("{{.%s}}", strings.ToLower(stdVar[i])), -1)
+	}
+	return source
+}
+
 // stdTypeMap contains a mapping from display type to real type.
 var stdTypeMap = map[string]string{
 	"":           "interface{}",
@@ -149,42 +203,125 @@ func (tpls *templatemanager) Dot(typemap map[string]string, types []string) map[
 	return d
 }
 
+// SkipSpecialize returns true when the fragment for the apply has been generated before. A
+// fragment generated without its tests is not skipped when the package wants tests, so the
+// tests are generated for it.
 func (tpls *templatemanager) SkipSpecialize(pkg PackageWriter, appl *apply) (bool, error) {
 	dot := tpls.Dot(pkg.Typemap(), appl.types)
 	name, err := tpls.expand(appl.nameID(), dot)
 	if err != nil {
-		return true, err
+		return true, templateError(appl.Generic, err)
+	}
+	if appl.unexported {
+		name = unexport(name)
 	}
-	return pkg.HasGeneratedSource(name), nil
+	return pkg.HasGeneratedSource(name) && !tpls.missingTest(pkg, appl, name), nil
+}
+
+// missingTest returns true when the package wants tests and the tests for the fragment
+// with the given name have not been generated, while the generic has tests.
+func (tpls *templatemanager) missingTest(pkg PackageWriter, appl *apply, name string) bool {
+	_, present := tpls.Tests[appl.testID()]
+	return present && pkg.WantsTests() && !pkg.HasGeneratedSource(name+"_test")
 }
 
 // Specialize will specialize a specific template using the info passed in via apply.
 // When specialization succeeds, a message describing what template has been applied
 // is returned. If an error was encountered, that is returned as second return value.
+// The tests of the generic are specialized along with it, also when only the tests
+// are missing because the fragment was generated before without them.
 func (tpls *templatemanager) Specialize(pkg PackageWriter, appl *apply) (string, error) {
 	dot := tpls.Dot(pkg.Typemap(), appl.types)
 
 	name, err := tpls.expand(appl.nameID(), dot)
 	if err != nil {
-		return "", err
+		return "", templateError(appl.Generic, err)
+	}
+	if appl.unexported {
+		name = unexport(name)
+	}
+	generated := pkg.HasGeneratedSource(name)
+	if generated && !tpls.missingTest(pkg, appl, name) {
+		return "", nil
+	}
+	// For display, generate signature based on template vars and add to messages.
+	sig := appl.Name
+	for i, tplvar := range appl.Vars {
+		sig = strings.Replace(sig, fmt.Sprintf("<%s>", tplvar), appl.types[i], -1)
 	}
-	if !pkg.HasGeneratedSource(name) {
+	typ := strings.Fields(sig)[0]
+	if appl.unexported {
+		typ = unexport(typ)
+		sig = unexport(sig)
+	}
+	if !generated {
 		source, err := tpls.expand(appl.sourceID(), dot)
 		if err != nil {
+			return "", templateError(appl.Generic, err)
+		}
+		source = lineDirectives(source, appl.Generic)
+		if appl.unexported {
+			// Rename the specialized type or function e.g. "StringStack" to "stringStack".
+			source = rename(source, export(typ), typ)
+		}
+		if err := checkCase(pkg, name, typ, source); err != nil {
 			return "", err
 		}
-		err = pkg.GenerateSource(appl.PackageName, name, source)
+		err = pkg.GenerateSource(Fragment{
+			PackageName: appl.PackageName,
+			PackagePath: appl.PackagePath,
+			Generic:     appl.Name,
+			Template:    appl.identifier,
+			Vars:        appl.Vars,
+			Types:       appl.types,
+			Type:        typ,
+			Name:        name,
+			Source:      source,
+			Imports:     appl.Imports,
+		})
 		if err != nil {
 			return "", err
 		}
-		// For display, generate signature based on template vars and add to messages.
-		sig := appl.Name
-		for i, tplvar := range appl.Vars {
-			sig = strings.Replace(sig, fmt.Sprintf("<%s>", tplvar), appl.types[i], -1)
+	}
+	if test, present := tpls.Tests[appl.testID()]; present {
+		source, err := tpls.expand(test.testID(), dot)
+		if err != nil {
+			return "", templateError(test, err)
+		}
+		if appl.unexported {
+			source = rename(source, export(typ), typ)
 		}
-		return sig, nil
+		err = pkg.GenerateSource(Fragment{
+			PackageName: test.PackageName,
+			PackagePath: test.PackagePath,
+			Generic:     test.Name,
+			Template:    test.identifier,
+			Vars:        test.Vars,
+			Types:       appl.types,
+			Type:        typ,
+			Name:        name + "_test",
+			Source:      lineDirectives(source, test),
+			Imports:     test.Imports,
+			Test:        true,
+		})
+		if err != nil {
+			return "", err
+		}
+		if generated {
+			return sig + " (tests)", nil
+		}
+	}
+	return sig, nil
+}
+
+// lineDirectives replaces the line markers e.g. "//line #0" in the source of the generic
+// by //line directives for the corresponding positions in its lines.
+// e.g. "//line /home/user/go/src/github.com/reactivego/rx/generic/observable.go:123"
+func lineDirectives(source string, t *Generic) string {
+	for i := len(t.Lines) - 1; i >= 0; i-- {
+		source = strings.Replace(source, fmt.Sprintf("//line #%d\n", i), fmt.Sprintf("//line %s\n", t.Lines[i]), -1)
 	}
-	return "", nil
+	return source
 }
 
 func (tpls *templatemanager) expand(id string, dot map[string]string) (string, error) {
@@ -198,7 +335,7 @@ func (tpls *templatemanager) expand(id string, dot map[string]string) (string, e
 
 func (tpls *templatemanager) GenerateCodeForType(pkg PackageWriter, signature string) (messages []string, err error) {
 	var applies []*apply
-	missing, err := generateApplies(tpls, signature, func(a *apply) (bool, error) {
+	missing, err := generateApplies(tpls, signature, pkg, func(a *apply) (bool, error) {
 		return tpls.SkipSpecialize(pkg, a)
 	}, func(a *apply) {
 		applies = append(applies, a)
@@ -225,16 +362,203 @@ func (tpls *templatemanager) GenerateCodeForType(pkg PackageWriter, signature st
 	return messages, nil
 }
 
+// Instantiate specializes the generic with the given name for the types. When the
+// generic is that of a type, the generics for the methods of the type are specialized
+// as well. When alias differs from the name of the specialized type or function, an
+// alias by that name is generated for it. Generics with the same name from different
+// packages are chosen between like for any other signature.
+func (tpls *templatemanager) Instantiate(pkg PackageWriter, alias, name string, types []string) (messages []string, err error) {
+	var candidates []*Generic
+	for _, t := range tpls.Generics {
+		if t.Name == name {
+			candidates = append(candidates, t)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("template %q not found", name)
+	}
+	generic, ambiguous := tpls.choose(name, candidates, pkg)
+	if ambiguous != "" {
+		messages = append(messages, ambiguous)
+	}
+	if len(generic.Vars) != len(types) {
+		return messages, fmt.Errorf("template %q needs %d types, got %d", name, len(generic.Vars), len(types))
+	}
+	signature := bind(name, generic.Vars, types)
+	signatures := []string{signature}
+	if len(strings.Fields(name)) == 1 {
+		// Collect the methods, skipping those with template vars of their own.
+		var methods []string
+		known := make(map[string]struct{})
+		for _, t := range tpls.Generics {
+			if fields := strings.Fields(t.Name); len(fields) == 2 && fields[0] == name && t.PackagePath == generic.PackagePath {
+				method := bind(t.Name, generic.Vars, types)
+				if _, present := known[method]; !present && !reTemplateVar.MatchString(method) {
+					known[method] = struct{}{}
+					methods = append(methods, method)
+				}
+			}
+		}
+		sort.Strings(methods)
+		signatures = append(signatures, methods...)
+	}
+	for _, sig := range signatures {
+		msgs, err := tpls.GenerateCodeForType(pkg, sig)
+		messages = append(messages, msgs...)
+		if err != nil {
+			return messages, err
+		}
+	}
+	if alias != "" && alias != signature {
+		msg, err := tpls.generateAlias(pkg, alias, signature)
+		if msg != "" {
+			messages = append(messages, msg)
+		}
+		if err != nil {
+			return messages, err
+		}
+	}
+	return messages, nil
+}
+
+// generateAlias generates an alias for the type or function in the signature.
+func (tpls *templatemanager) generateAlias(pkg PackageWriter, alias, signature string) (string, error) {
+	if len(strings.Fields(signature)) != 1 {
+		return "", fmt.Errorf("cannot generate alias %q for method %q", alias, signature)
+	}
+	if pkg.HasGeneratedSource(alias) {
+		return "", nil
+	}
+	tpl, types, _ := tpls.find(signature, nil, pkg)
+	if tpl == nil {
+		return "", fmt.Errorf("template for %q not found", signature)
+	}
+	source, err := tpls.expand(tpl.sourceID(), tpls.Dot(pkg.Typemap(), types))
+	if err != nil {
+		return "", templateError(tpl, err)
+	}
+	// A type gets a type alias, a function gets a variable.
+	decl := "var"
+	if regexp.MustCompile(`(?m)^type[[:space:]]+` + regexp.QuoteMeta(signature) + `\b`).MatchString(source) {
+		decl = "type"
+	}
+	err = pkg.GenerateSource(Fragment{
+		PackageName: tpl.PackageName,
+		PackagePath: tpl.PackagePath,
+		Generic:     tpl.Name,
+		Template:    tpl.identifier,
+		Vars:        tpl.Vars,
+		Types:       types,
+		Type:        alias,
+		Name:        alias,
+		Source:      fmt.Sprintf("%s %s = %s\n", decl, alias, signature),
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("generating alias %q for %q", alias, signature), nil
+}
+
+// bind replaces the template vars in the name of a generic by the types.
+// e.g. "<Foo>Stack Push" with vars ["Foo"] and types ["Int"] -> "IntStack Push"
+func bind(name string, vars, types []string) string {
+	for i, varname := range vars {
+		name = strings.Replace(name, fmt.Sprintf("<%s>", varname), types[i], -1)
+	}
+	return name
+}
+
+// rename replaces all occurrences of the identifier name in the source by newname.
+func rename(source, name, newname string) string {
+	return regexp.MustCompile(`\b`+regexp.QuoteMeta(name)+`\b`).ReplaceAllString(source, newname)
+}
+
+// checkCase returns an error when the fragment with the given name is generated in both the
+// exported and the unexported form e.g. "StringStack" and "stringStack", and its source
+// declares identifiers other than the specialized type or function typ. Both forms would
+// declare those e.g. "var zeroString string", so the package would not build.
+func checkCase(pkg PackageWriter, name, typ, source string) error {
+	other := export(name)
+	if other == name {
+		other = unexport(name)
+	}
+	if other == name || !pkg.HasGeneratedSource(other) {
+		return nil
+	}
+	var names []string
+	for _, n := range declaredNames(source) {
+		if n != typ {
+			names = append(names, n)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	return fmt.Errorf("cannot generate both %q and %q, as both would declare %s; use only one of them", other, name, strings.Join(names, ", "))
+}
+
+// declaredNames returns the names of the package level identifiers declared by the
+// source, leaving out methods. Returns nil when the source does not parse.
+func declaredNames(source string) []string {
+	file, err := parser.ParseFile(token.NewFileSet(), "", "package p\n"+source, 0)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if decl.Recv == nil {
+				names = append(names, decl.Name.Name)
+			}
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					names = append(names, spec.Name.Name)
+				case *ast.ValueSpec:
+					for _, n := range spec.Names {
+						if n.Name != "_" {
+							names = append(names, n.Name)
+						}
+					}
+				}
+			}
+		}
+	}
+	return names
+}
+
+// export returns the name with its first letter in upper case e.g. "stringStack" -> "StringStack"
+func export(name string) string {
+	r, size := utf8.DecodeRuneInString(name)
+	return string(unicode.ToUpper(r)) + name[size:]
+}
+
+// unexport returns the name with its first letter in lower case e.g. "StringStack" -> "stringStack"
+func unexport(name string) string {
+	r, size := utf8.DecodeRuneInString(name)
+	return string(unicode.ToLower(r)) + name[size:]
+}
+
+// reTemplateVar matches a template var e.g. "<Foo>"
+var reTemplateVar = regexp.MustCompile("<[[:word:]]+>")
+
 // apply contains all info needed to call ExecuteGeneric on a go template
 // in order to apply the template with name Generic.Name to the data passed
 // in Dot.
+// When unexported is set, the specialized type or function is generated with
+// its first letter in lower case.
 type apply struct {
 	*Generic
-	types []string
+	types      []string
+	unexported bool
 }
 
-// generateApplies will recurse down the needs tree of templates matching the signature
-func generateApplies(tpls *templatemanager, signature string, skip func(*apply) (bool, error), next func(*apply)) ([]string, error) {
+// generateApplies will recurse down the needs tree of templates matching the signature.
+// The package is used to choose between templates with the same name from different
+// template libraries.
+func generateApplies(tpls *templatemanager, signature string, pkg PackageWriter, skip func(*apply) (bool, error), next func(*apply)) ([]string, error) {
 	var (
 		known    = make(map[string]struct{})
 		generate func(string, []string) ([]string, error)
@@ -245,22 +569,47 @@ func generateApplies(tpls *templatemanager, signature string, skip func(*apply)
 
 		// Given a type signature e.g. "ObservableInt32 MapFloat64" then tpl is the template that matches that.
 		// The types string slice contain the types in the signature e.g. ["Int32","Float64"]
-		tpl, types := tpls.find(signature, parentTypes)
+		tpls.tracef("resolving %q", signature)
+		tpls.depth++
+		defer func() { tpls.depth-- }()
+		tpl, types, ambiguous, unexported := tpls.findCase(signature, parentTypes, pkg)
+		if ambiguous != "" {
+			missing = append(missing, ambiguous)
+		}
 		if tpl != nil {
 			if len(tpl.Vars) != len(types) {
-				return missing, fmt.Errorf("signature %q does not match template %q", signature, tpl.Name)
+				return missing, templateError(tpl, fmt.Errorf("signature %q does not match", signature))
+			}
+			if tpls.trace != nil {
+				tpls.tracef("dot %s", formatDot(tpls.Dot(pkg.Typemap(), types)))
+			}
+			application := &apply{tpl, types, unexported}
+			if err := tpls.graphNode(signature, application, pkg); err != nil {
+				return missing, err
 			}
-			application := &apply{tpl, types}
 			skip, err := skip(application)
+			if skip {
+				tpls.tracef("already generated, skipping it and its needs")
+			}
 			if err == nil && !skip {
 				for _, need := range tpl.Needs {
+					kind := tpls.needKind(tpl, need)
 					// Convert need of the form e.g. Observable<Foo> into ObservableInt32 assuming Foo == "Int32"
 					for i, varname := range tpl.Vars {
 						need = strings.Replace(need, fmt.Sprintf("<%s>", varname), types[i], -1)
 					}
+					// Needs of an unexported type e.g. "stringStack Push" need "stringStack" instead of "StringStack".
+					if unexported && strings.Fields(need)[0] == export(strings.Fields(signature)[0]) {
+						need = unexport(need)
+					}
+
+					tpls.graphEdge(signature, need, kind)
 
 					// Check if this need is known
-					if _, present := known[need]; !present {
+					if _, present := known[need]; present {
+						tpls.tracef("needs %q, already resolved", need)
+					} else {
+						tpls.tracef("needs %q", need)
 						msgs, err := generate(need, types)
 						missing = append(missing, msgs...)
 						if err != nil {
@@ -278,13 +627,18 @@ func generateApplies(tpls *templatemanager, signature string, skip func(*apply)
 		// Create alternative signatures by combinging embeded types and original method part.
 
 		//  Looked for e.g. for signature "ConnectableInt SubscribeOn" doesn't exist because no template for this.
+		embedded := false
 		if fields := strings.Fields(signature); len(fields) == 2 {
 			name, method := fields[0], fields[1]
 			//  Lookup "ConnectableInt" by itself and see if embeds other types, yes "Observable<Foo>"
-			tpl, types := tpls.find(name, parentTypes)
+			tpls.tracef("no template for %q, looking for the types embedded by %q", signature, name)
+			tpl, types, _, _ := tpls.findCase(name, parentTypes, pkg)
+			if tpl != nil && len(tpl.Embeds) == 0 {
+				tpls.tracef("template %q does not embed any types", tpl.Name)
+			}
 			if tpl != nil && len(tpl.Embeds) > 0 {
 				if len(tpl.Vars) != len(types) {
-					return missing, fmt.Errorf("signature %q does not match template %q", name, tpl.Name)
+					return missing, templateError(tpl, fmt.Errorf("signature %q does not match", name))
 				}
 				// Found e.g. ConnectableInt by itself, and it has embeded types.
 				for _, embed := range tpl.Embeds {
@@ -295,6 +649,9 @@ func generateApplies(tpls *templatemanager, signature string, skip func(*apply)
 					// Frankenconcat into e.g. "ObservableInt SubscribeOn"
 					embed = fmt.Sprintf("%s %s", embed, method)
 					// Now generate for e.g. "ObservableInt SubscribeOn" if it is not already known
+					tpls.tracef("embeds %q, trying %q", strings.Fields(embed)[0], embed)
+					tpls.graphEdge(signature, embed, EmbedsEdge)
+					embedded = true
 					if _, present := known[embed]; !present {
 						msgs, err := generate(embed, types)
 						missing = append(missing, msgs...)
@@ -309,6 +666,7 @@ func generateApplies(tpls *templatemanager, signature string, skip func(*apply)
 		// No need to return an error, the type checking loop will eventually
 		// report missing type to the user.
 		missing = append(missing, fmt.Sprintf("missing %q", signature))
+		tpls.graphMissing(signature, !embedded)
 		//fmt.Println("MISSING", signature)
 		return missing, nil
 	}
@@ -317,19 +675,111 @@ func generateApplies(tpls *templatemanager, signature string, skip func(*apply)
 
 // find matches the signature against a sorted list of templates. If types has
 // entries, then the types matched from the signature must be present in the
-// types list.
-func (tpls *templatemanager) find(signature string, types []string) (*Generic, []string) {
-	for _, t := range tpls.Generics {
+// types list. When templates with the same name from different template libraries
+// match, the one from the library preferred by the package is used. Without a
+// preference, the one from the only library imported directly by the package is
+// used. Otherwise the one from the library whose import path sorts first is used
+// and a message reporting the ambiguity is returned.
+func (tpls *templatemanager) find(signature string, types []string, pkg PackageWriter) (*Generic, []string, string) {
+	t, sigtypes := tpls.match(tpls.Generics, signature, types)
+	if t == nil {
+		return nil, nil, ""
+	}
+	// Collect all matching templates with the same name from other libraries.
+	var candidates []*Generic
+	trace := tpls.trace
+	tpls.trace = nil
+	for _, c := range tpls.Generics {
+		if c.Name == t.Name {
+			if c, _ := tpls.match([]*Generic{c}, signature, types); c != nil {
+				candidates = append(candidates, c)
+			}
+		}
+	}
+	tpls.trace = trace
+	t, ambiguous := tpls.choose(signature, candidates, pkg)
+	return t, sigtypes, ambiguous
+}
+
+// choose returns the generic to use out of the candidates, generics with the same name
+// from different packages. The candidate preferred by jig:prefer is used, otherwise the
+// only one from a package imported directly. When that does not decide it, the candidate
+// from the package whose import path sorts first is used and a message reporting the
+// ambiguity is returned.
+func (tpls *templatemanager) choose(signature string, candidates []*Generic, pkg PackageWriter) (*Generic, string) {
+	if len(candidates) == 1 {
+		t := candidates[0]
+		tpls.tracef("using template %q (%s)", t.Name, t.PackagePath)
+		return t, ""
+	}
+	candidates = append([]*Generic(nil), candidates...)
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].PackagePath < candidates[j].PackagePath
+	})
+	t := candidates[0]
+	var paths []string
+	for _, c := range candidates {
+		paths = append(paths, c.PackagePath)
+	}
+	tpls.tracef("template %q is defined in packages %q", t.Name, paths)
+	for _, path := range pkg.Prefer() {
+		for _, c := range candidates {
+			if c.PackagePath == path {
+				tpls.tracef("using template %q (%s) preferred by jig:prefer", c.Name, c.PackagePath)
+				return c, ""
+			}
+		}
+	}
+	var direct []*Generic
+	for _, c := range candidates {
+		if pkg.Imports(c.PackagePath) {
+			direct = append(direct, c)
+		}
+	}
+	if len(direct) == 1 {
+		tpls.tracef("using template %q (%s) from the only package imported directly", direct[0].Name, direct[0].PackagePath)
+		return direct[0], ""
+	}
+	tpls.tracef("using template %q (%s), the choice is ambiguous", t.Name, t.PackagePath)
+	return t, fmt.Sprintf("ambiguous %q matches template %q in packages %q, using %q (see jig:prefer)", signature, t.Name, paths, t.PackagePath)
+}
+
+// findCase is like find, but a signature starting with a lower case letter e.g.
+// "stringStack" that does not match a template starting with that same letter,
+// is matched as if it started with an upper case letter e.g. "StringStack". The
+// last return value is true in that case, as the specialized type or function
+// must then be generated unexported. Methods keep their original visibility.
+func (tpls *templatemanager) findCase(signature string, types []string, pkg PackageWriter) (*Generic, []string, string, bool) {
+	t, sigtypes, ambiguous := tpls.find(signature, types, pkg)
+	if exported := export(signature); exported != signature && (t == nil || strings.HasPrefix(t.Name, "<")) {
+		tpls.tracef("%q starts with a lower case letter, trying %q for an unexported specialization", signature, exported)
+		if u, utypes, uambiguous := tpls.find(exported, types, pkg); u != nil {
+			return u, utypes, uambiguous, true
+		}
+	}
+	return t, sigtypes, ambiguous, false
+}
+
+// match returns the first generic of the sorted list of generics that matches
+// the signature, together with the types matched from the signature. Every
+// generic tried is traced with its regular expression and, when it is rejected,
+// the reason why.
+func (tpls *templatemanager) match(generics []*Generic, signature string, types []string) (*Generic, []string) {
+	for _, t := range generics {
 		if t.signature != nil {
 			sigmatch := t.signature.FindStringSubmatch(signature)
 			if len(sigmatch) == 0 {
+				tpls.tracef("template %q (%s) does not match %s", t.Name, t.PackagePath, t.signature)
 				continue
 			}
+			tpls.tracef("template %q (%s) matches %s binding %s", t.Name, t.PackagePath, t.signature, formatBindings(t.Vars, sigmatch[1:]))
 			if len(types) != 0 && !contains(types, sigmatch[1:]) {
+				tpls.tracef("  rejected, types <%s> are not all among the types <%s> of the template that needs it", strings.Join(sigmatch[1:], ">,<"), strings.Join(types, ">,<"))
 				continue
 			}
 			if len(t.RequiredVars) != 0 {
 				if len(t.Vars) != len(sigmatch[1:]) {
+					tpls.tracef("  rejected, it has %d template vars but matched %d types", len(t.Vars), len(sigmatch[1:]))
 					continue
 				}
 				vartypes := make(map[string]string)
@@ -340,7 +790,7 @@ func (tpls *templatemanager) find(signature string, types []string) (*Generic, [
 				rejectMatch := false
 				for _, varname := range t.RequiredVars {
 					if vartypes[varname] == "" {
-						fmt.Printf(">>>> %+q %+q %+q %+q\n", vartypes, sigmatch[1:], t.Vars, t.RequiredVars)
+						tpls.tracef("  rejected, required var %s is not assigned a type", varname)
 						rejectMatch = true
 						break
 					}
@@ -349,6 +799,7 @@ func (tpls *templatemanager) find(signature string, types []string) (*Generic, [
 					continue
 				}
 			}
+			tpls.tracef("  accepted, templates with more vars and longer names are tried first")
 			return t, sigmatch[1:]
 		}
 	}
@@ -3,7 +3,8 @@ package templ
 // byNumVarsAndLength attaches sort methods to a []*Generic instance.
 // It sorts first on the number of variables from most to least number of
 // variables. Withing each tier sorts generics on length of their name, from
-// short to longest.
+// short to longest. Generics with names of equal length are ordered by name and
+// then by package path.
 type byNumVarsAndLength []*Generic
 
 func (a byNumVarsAndLength) Len() int {
@@ -20,7 +21,14 @@ func (a byNumVarsAndLength) Less(i, j int) bool {
 		return false
 	}
 	// Equal number of variables, then compare length of their Name.
-	return len(a[i].Name) < len(a[j].Name)
+	if len(a[i].Name) != len(a[j].Name) {
+		return len(a[i].Name) < len(a[j].Name)
+	}
+	// Equal length, then compare Name and PackagePath to make the order stable.
+	if a[i].Name != a[j].Name {
+		return a[i].Name > a[j].Name
+	}
+	return a[i].PackagePath > a[j].PackagePath
 }
 
 func (a byNumVarsAndLength) Swap(i, j int) {
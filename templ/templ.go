@@ -10,7 +10,10 @@ type Generic struct {
 	// Packagename is the name of the package in which the generic was found.
 	// e.g. "rx"
 	PackageName string
-	// Name of the generic uniquely identifies it.
+	// PackagePath is the import path of the package in which the generic was found.
+	// e.g. "github.com/reactivego/rx/generic"
+	PackagePath string
+	// Name of the generic uniquely identifies it within the package.
 	// e.g. "Observable<Foo> Map<Bar>"
 	Name string
 	// Vars contains the template vars found in the generic.
@@ -32,6 +35,32 @@ type Generic struct {
 	// e.g. ["Foo"]
 	RequiredVars []string
 
+	// Imports contains the import specs of the file the generic was found in
+	// that are actually used by the source of the generic.
+	// e.g. [{Name:"crand", Path:"crypto/rand"}]
+	Imports []Import
+
+	// Lines contains the original positions of the declarations in the source
+	// of the generic, as the path of the file and the line. The source refers to
+	// them by index with line markers of the form "//line #0". When specialized,
+	// the markers are replaced by //line directives for the positions.
+	// e.g. ["/home/user/go/src/github.com/reactivego/rx/generic/observable.go:123"]
+	Lines []string
+
+	// Test is set for the generic of a test. Its source contains the tests for the
+	// generic with the same name, and is specialized along with it when the package
+	// wants tests.
+	Test bool
+
+	// Common is set for a generic marked with jig:common. It contains support code
+	// and is added to the Needs of every other generic in its package.
+	Common bool
+
+	// TypeParams is set for a generic converted from a Go declaration with type
+	// parameters. Its name ends in adjacent template vars e.g. "Map<JigA><JigB>",
+	// the types for which are told apart by their upper case first letter.
+	TypeParams bool
+
 	// identifier is the generic name with all spaces and angle brackets around
 	// the template variable names removed.
 	// e.g. "ObservableFoo_MapBar"
@@ -42,12 +71,66 @@ type Generic struct {
 	signature *regexp.Regexp
 }
 
+// Import is an import spec as found in the file that contains a generic.
+type Import struct {
+	// Name is the name given to the import in the import spec. It is empty
+	// when the import is not renamed and it is "." for a dot import.
+	// e.g. "crand"
+	Name string
+	// Path is the path of the imported package.
+	// e.g. "crypto/rand"
+	Path string
+}
+
+// Fragment is a source fragment generated by specializing a generic.
+type Fragment struct {
+	// PackageName is the name of the package in which the generic was found.
+	// e.g. "rx"
+	PackageName string
+	// PackagePath is the import path of the package in which the generic was found.
+	// e.g. "github.com/reactivego/rx/generic"
+	PackagePath string
+	// Generic is the name of the generic.
+	// e.g. "Observable<Foo> Map<Bar>"
+	Generic string
+	// Template is the name of the generic with spaces replaced by underscores
+	// and angle brackets around the template vars removed.
+	// e.g. "ObservableFoo_MapBar"
+	Template string
+	// Vars contains the template vars of the generic.
+	// e.g. ["Foo", "Bar"]
+	Vars []string
+	// Types contains the types bound to the template vars.
+	// e.g. ["Int", "String"]
+	Types []string
+	// Type is the type (or function) being specialized, i.e. the first part
+	// of the signature of the fragment.
+	// e.g. "ObservableInt"
+	Type string
+	// Name uniquely identifies the fragment. It is the value of the jig:name
+	// pragma written in front of the source.
+	// e.g. "ObservableInt_MapString"
+	Name string
+	// Source is the specialized source code of the fragment.
+	Source string
+	// Imports contains the imports used by the source.
+	Imports []Import
+	// Test is set when the source contains the tests for the fragment of the same
+	// name without the "_test" suffix.
+	// e.g. "StringStack_Pop_test"
+	Test bool
+}
+
 func (t Generic) nameID() string {
-	return "N" + t.identifier
+	return "N" + t.PackagePath + "." + t.identifier
 }
 
 func (t Generic) sourceID() string {
-	return "S" + t.identifier
+	return "S" + t.PackagePath + "." + t.identifier
+}
+
+func (t Generic) testID() string {
+	return "T" + t.PackagePath + "." + t.identifier
 }
 
 // PackageWriter is the interface expected by the specializer to add
@@ -60,14 +143,28 @@ type PackageWriter interface {
 	// need to know about the real type myfoo
 	Typemap() map[string]string
 
+	// Prefer returns the import paths of template libraries in order of preference.
+	// Used to choose between generics with the same name found in different libraries.
+	// e.g. ["github.com/reactivego/rx/generic"]
+	Prefer() []string
+
+	// Imports returns true when the package directly imports the template library
+	// with the given import path. When there is no explicit preference, a generic
+	// from a library imported directly is preferred over one imported indirectly.
+	Imports(path string) bool
+
 	// HasGeneratedSource will take a fragment name and return true if this has
 	// already been generated as part of the package.
 	HasGeneratedSource(name string) bool
 
-	// GenerateSource given a package name, a fragment name and the source content
-	// for a fragment will generate and append the source to the package, returning
-	// an error if something goes wrong.
-	GenerateSource(packageName, name, source string) error
+	// WantsTests returns true when the package wants the tests of specialized generics.
+	// The tests are then also generated for fragments generated before without them.
+	WantsTests() bool
+
+	// GenerateSource given a fragment will generate and append the source of
+	// the fragment to the package, returning an error if something goes wrong.
+	// A package that does not want tests ignores fragments with Test set.
+	GenerateSource(fragment Fragment) error
 }
 
 // Specializer is used during the generics definition phase to Add generics while
@@ -78,7 +175,8 @@ type PackageWriter interface {
 // will then use GenerateCodeForType() to find a matching generic and then generate code that
 // implements the missing type using the passed in PackageWriter interface.
 type Specializer interface {
-	// Add will take the source and create a template based on it.
+	// Add will take the source and create a template based on it. The source of
+	// a generic with Test set is specialized along with the generic of the same name.
 	Add(t Generic, source string) error
 
 	// After adding all generics call Sort() once to sort all generics from longest to shortest Name length.
@@ -90,4 +188,20 @@ type Specializer interface {
 	// unexpected went wrong during the generating process. If no code can be generated for a
 	// signature, that is not considered an error.
 	GenerateCodeForType(pkg PackageWriter, signature string) ([]string, error)
+
+	// Explain describes how the signature is resolved: the generic it matches, the types bound
+	// to the template vars and the fragments that would be generated to implement it. The
+	// signature may also be given as an identifier e.g. "ObservableIntMapString".
+	Explain(pkg PackageWriter, signature string) ([]string, error)
+
+	// Graph returns the dependency graph of the generics through their needs, embeds and
+	// common support code. When signature is not empty, the graph is instantiated for it.
+	Graph(pkg PackageWriter, signature string) (*Graph, []string, error)
+
+	// Instantiate will specialize the generic with the given name for the types, without
+	// a missing type, function or method having to be detected first. For the generic of a
+	// type, the generics of its methods are specialized too. When alias is not empty, an
+	// alias with that name is generated for the specialized type or function.
+	// e.g. Instantiate(pkg, "MyInts", "<Foo>Stack", []string{"Int"})
+	Instantiate(pkg PackageWriter, alias, name string, types []string) ([]string, error)
 }
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/reactivego/jig/pkg"
+	"github.com/reactivego/jig/templ"
+)
+
+// watchMain implements "jig watch", which generates the code for the package and then
+// generates it again every time a .go file changes in the package dir, the output dir
+// or the dir of one of the imported template libraries. Only missing code is generated
+// by default. The templates and the packages found for imports are kept between runs,
+// unless files changed in their dirs.
+func watchMain(cmd *subcommand, args []string) int {
+	var (
+		f               genFlags
+		interval, delay time.Duration
+	)
+	flags := cmd.flagSet()
+	f.add(flags)
+	flags.BoolVarP(&f.missing, "missing", "m", true, "Only generate code that is missing, use --missing=false to generate all code again")
+	flags.DurationVar(&interval, "interval", 500*time.Millisecond, "How often to look for changed files")
+	flags.DurationVar(&delay, "delay", 300*time.Millisecond, "How long files must be left unchanged before generating code")
+	if ok, status := parse(flags, args); !ok {
+		return status
+	}
+	dir := dirArg(flags, 0)
+
+	var (
+		previous  *pkg.Package
+		templates = &templateCache{}
+		changed   []string
+		dirs      = map[string]struct{}{filepath.Clean(dir): {}}
+	)
+	for {
+		p := f.packageFor(dir, flags)
+		if previous != nil {
+			p.ReuseImports(previous, changed)
+		}
+		previous = p
+		templates.invalidate(changed)
+		// A file that does not parse is reported, and then jig waits for it to be fixed.
+		if err := p.ParseDir(); !printedError(f.verbose, nil, err) {
+			f.regenerate(p, templates)
+			// Keep watching the dirs of libraries that are no longer imported, the
+			// package may not have been checked when generating failed.
+			for _, dir := range p.WatchDirs() {
+				dirs[dir] = struct{}{}
+			}
+		}
+		if f.verbose {
+			fmt.Fprintf(stdout, "watching %d dirs for changes\n", len(dirs))
+		}
+		changed = waitForChanges(dirs, interval, delay)
+	}
+}
+
+// regenerate generates the code for the package and writes the files that changed,
+// printing what was written and the errors that jig could not fix.
+func (f *genFlags) regenerate(p *pkg.Package, templates *templateCache) {
+	errors, err := f.run(p, templates, nil)
+	if err != nil {
+		return
+	}
+	// Only write files when the generated code changed, so the files written
+	// are not reported as changed the next time around.
+	changes, err := p.DiffGeneratedSources(ioutil.Discard)
+	if printedError(false, nil, err) {
+		return
+	}
+	if len(changes) > 0 {
+		messages, err := p.WriteGeneratedSources()
+		if printedError(p.Events == nil, messages, err) {
+			return
+		}
+	}
+	printErrors(p, errors)
+	if len(changes) == 0 && len(errors) == 0 && p.Events == nil {
+		fmt.Fprintln(stdout, "generated code is up to date")
+	}
+}
+
+// templateCache keeps the templates loaded for the package between runs, together
+// with the dirs they were loaded from.
+type templateCache struct {
+	tplr templ.Specializer
+	dirs []string
+}
+
+// lookup returns the templates when they were loaded from the dirs the templates
+// available to the package are loaded from, nil otherwise. A nil cache has none.
+func (c *templateCache) lookup(p *pkg.Package) templ.Specializer {
+	if c == nil || c.tplr == nil || strings.Join(c.dirs, "\n") != strings.Join(p.TemplateDirs(), "\n") {
+		return nil
+	}
+	return c.tplr
+}
+
+// store keeps the templates loaded for the package.
+func (c *templateCache) store(p *pkg.Package, tplr templ.Specializer) {
+	if c != nil {
+		c.tplr, c.dirs = tplr, p.TemplateDirs()
+	}
+}
+
+// invalidate drops the templates when files changed in one of the dirs they were loaded from.
+func (c *templateCache) invalidate(changed []string) {
+	for _, dir := range changed {
+		for _, loaded := range c.dirs {
+			if filepath.Clean(dir) == loaded {
+				c.tplr = nil
+			}
+		}
+	}
+}
+
+// modTimes maps the path of every .go file in a set of dirs to its modification time.
+type modTimes map[string]time.Time
+
+// scan returns the modification times of the .go files in the dirs.
+func scan(dirs map[string]struct{}) modTimes {
+	times := make(modTimes)
+	for dir := range dirs {
+		paths, _ := filepath.Glob(filepath.Join(dir, "*.go"))
+		for _, path := range paths {
+			if info, err := os.Stat(path); err == nil {
+				times[path] = info.ModTime()
+			}
+		}
+	}
+	return times
+}
+
+// changedDirs returns the dirs of the files that were added, removed or modified.
+func changedDirs(before, after modTimes) []string {
+	changed := make(map[string]struct{})
+	for path, t := range after {
+		if prev, present := before[path]; !present || !prev.Equal(t) {
+			changed[filepath.Dir(path)] = struct{}{}
+		}
+	}
+	for path := range before {
+		if _, present := after[path]; !present {
+			changed[filepath.Dir(path)] = struct{}{}
+		}
+	}
+	var dirs []string
+	for dir := range changed {
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+// waitForChanges looks for changed .go files in the dirs every interval. Once a file has
+// changed, it waits until the files have been left unchanged for delay, so a burst of saves
+// leads to a single run of jig. Returns the dirs in which files changed.
+func waitForChanges(dirs map[string]struct{}, interval, delay time.Duration) []string {
+	before := scan(dirs)
+	after := before
+	for len(changedDirs(before, after)) == 0 {
+		time.Sleep(interval)
+		after = scan(dirs)
+	}
+	for settled := false; !settled; {
+		time.Sleep(delay)
+		latest := scan(dirs)
+		settled = len(changedDirs(after, latest)) == 0
+		after = latest
+	}
+	return changedDirs(before, after)
+}